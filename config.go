@@ -0,0 +1,158 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultScrapeInterval = time.Minute
+
+type Config struct {
+	ScrapeInterval time.Duration  `yaml:"scrape_interval"` // Default polling interval for every target
+	Targets        []TargetConfig `yaml:"targets"`         // Modems to poll in the background
+}
+
+type TargetConfig struct {
+	Host           string        `yaml:"host"`     // Hostname or network address of SB8200 modem
+	Username       string        `yaml:"username"` // Defaults to "admin"
+	Password       string        `yaml:"password"`
+	Interval       time.Duration `yaml:"interval"`        // Overrides the global scrape_interval
+	LoginURL       string        `yaml:"login_url"`       // Overrides -modem.login-url
+	Scheme         string        `yaml:"scheme"`          // Overrides -modem.scheme
+	ExpectedSerial string        `yaml:"expected_serial"` // Overrides -modem.expected-serial
+	ExpectedMAC    string        `yaml:"expected_mac"`    // Overrides -modem.expected-mac
+	Backup         *BackupConfig `yaml:"backup"`          // Modem scraped only when this one fails
+}
+
+type BackupConfig struct {
+	Host     string `yaml:"host"`     // Hostname or network address of the backup modem
+	Username string `yaml:"username"` // Defaults to "admin"
+	Password string `yaml:"password"`
+}
+
+// ConfigErrors lists every problem found in a config file.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// LoadConfig reads a YAML config file and fills in defaults. A config that
+// parses but doesn't validate is reported as ConfigErrors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	if config.ScrapeInterval <= 0 {
+		config.ScrapeInterval = defaultScrapeInterval
+	}
+	for i := range config.Targets {
+		target := &config.Targets[i]
+		if target.Username == "" {
+			target.Username = "admin"
+		}
+		if target.Interval <= 0 {
+			target.Interval = config.ScrapeInterval
+		}
+		if backup := target.Backup; backup != nil && backup.Username == "" {
+			backup.Username = "admin"
+		}
+	}
+
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// Validate returns every problem with the config rather than only the
+// first, so that they can all be fixed in one go.
+func (config *Config) Validate() (errs ConfigErrors) {
+	if len(config.Targets) == 0 {
+		errs = append(errs, errors.New("no targets configured"))
+	}
+	hosts := make(map[string]int)
+	for i, target := range config.Targets {
+		if err := validateHost(target.Host); err != nil {
+			errs = append(errs, fmt.Errorf("target %d: %w", i, err))
+		} else if first, ok := hosts[target.Host]; ok {
+			errs = append(errs, fmt.Errorf("target %d: host %s is already target %d", i, target.Host, first))
+		} else {
+			hosts[target.Host] = i
+		}
+		if target.LoginURL != "" {
+			if loginURL, err := url.Parse(target.LoginURL); err != nil || (loginURL.Scheme != "http" && loginURL.Scheme != "https") || loginURL.Host == "" {
+				errs = append(errs, fmt.Errorf("target %d: login_url %q is not an http(s) URL", i, target.LoginURL))
+			}
+		}
+		if target.Scheme != "" && target.Scheme != "http" && target.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("target %d: scheme %q must be http or https", i, target.Scheme))
+		}
+		if backup := target.Backup; backup != nil {
+			if err := validateHost(backup.Host); err != nil {
+				errs = append(errs, fmt.Errorf("target %d: backup %w", i, err))
+			} else if backup.Host == target.Host {
+				errs = append(errs, fmt.Errorf("target %d: backup host is the target itself", i))
+			}
+		}
+	}
+	return
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// validateHost checks that host looks like something the modem can be
+// reached at: an IP address or hostname, optionally with a port.
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("missing host")
+	}
+	name := host
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		var port string
+		var err error
+		if name, port, err = net.SplitHostPort(host); err != nil {
+			return fmt.Errorf("host %q: %w", host, err)
+		}
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("host %q has an invalid port", host)
+		}
+	}
+	if net.ParseIP(name) == nil && !hostnameRegexp.MatchString(name) {
+		return fmt.Errorf("host %q is neither an IP address nor a hostname", host)
+	}
+	return nil
+}
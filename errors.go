@@ -0,0 +1,71 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentErrorsSize bounds how many scrape errors each Exporter remembers.
+const recentErrorsSize = 32
+
+// errorRecord is a failed scrape as reported by /debug/errors.
+type errorRecord struct {
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host"`
+	Error string    `json:"error"`
+}
+
+// errorRing keeps the most recent scrape errors, overwriting the oldest once
+// full.
+type errorRing struct {
+	mu      sync.Mutex
+	entries []errorRecord
+	next    int // Index the next error is written to once entries is full
+}
+
+func (r *errorRing) add(entry errorRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) < recentErrorsSize {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % recentErrorsSize
+}
+
+// list returns the remembered errors, oldest first.
+func (r *errorRing) list() []errorRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]errorRecord, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
+
+// RecentErrors returns the recent scrape errors of every modem, oldest first.
+func (es Exporters) RecentErrors() []errorRecord {
+	entries := []errorRecord{}
+	for _, e := range es {
+		entries = append(entries, e.recentErrors.list()...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
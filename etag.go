@@ -0,0 +1,80 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// cacheVersion identifies the data the exporters would currently serve: the
+// time of every cached background scrape and the maintenance state. ok is
+// false while any exporter scrapes on demand or has no cached scrape yet,
+// since the response can then change on every request.
+func (es Exporters) cacheVersion() (version string, ok bool) {
+	var b strings.Builder
+	for _, e := range es {
+		e.mu.Lock()
+		polling, cached := e.polling, e.cached
+		e.mu.Unlock()
+		if !polling || cached == nil {
+			return "", false
+		}
+		fmt.Fprintf(&b, "%s=%d,%t;", e.Host, cached.time.UnixNano(), e.Maintenance.Enabled())
+	}
+	return b.String(), true
+}
+
+// etagCache tags the output of next with an ETag derived from version, so
+// that a conditional request for modem data it already has is answered with
+// 304 Not Modified without rendering anything. Every other request is
+// rendered afresh, as the cache age, skipped scrape counters and process
+// metrics keep changing between polls. Requests are passed straight to next
+// whenever version isn't ok.
+func etagCache(version func() (string, bool), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, ok := version()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The output depends on the negotiated format and compression.
+		hash := fnv.New64a()
+		fmt.Fprintf(hash, "%s\n%s\n%s", current, r.Header.Get("Accept"), r.Header.Get("Accept-Encoding"))
+		etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept, Accept-Encoding")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, weakly
+// compared as RFC 7232 asks for If-None-Match.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
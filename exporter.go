@@ -15,509 +15,1941 @@
 package main
 
 import (
-	"crypto/tls"
-	b64 "encoding/base64"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"regexp"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/ocelotsloth/sb8200-exporter/pkg/sb8200"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 )
 
-type DownstreamChannel struct {
-	ChannelID           string  // Channel identifier (string)
-	LockStatus          float64 // Whether the channel is locked or not (boolean)
-	Modulation          string  // Type of modulation used by channel
-	Frequency           string  // Frequency the channel is operating on (Hz)
-	Power               float64 // Power level (dBmV)
-	SNR                 float64 // SNR/MER (dB)
-	CorrectedErrors     float64 // Counter, resets to 0 on modem reboot (n)
-	UncorrectableErrors float64 // Counter, resets to 0 on modem reboot (n)
-}
-
-type UpstreamChannel struct {
-	Channel       string  // Channel Number (string)
-	ChannelID     string  // Channel ID (string)
-	LockStatus    float64 // Whether the channel is locked or not (boolean)
-	USChannelType string  // Upstream channel modulation
-	Frequency     string  // Frequency the channel is operating on (Hz)
-	Width         string  // Channel width (Hz)
-	Power         float64 // Power level (dBmV)
-}
-
-type ArrisModem struct {
-	Host                     string              // Hostname or network address of SB8200 modem
-	ConnectivityState        float64             // Is the modem connected to upstream provider (boolean)
-	Uptime                   float64             // From product info page, Uptime (Seconds)
-	HardwareVersion          string              // From product info page
-	SoftwareVersion          string              // From product info page
-	MACAddress               string              // From product info page
-	SerialNumber             string              // From product info page
-	DownstreamBondedChannels []DownstreamChannel // From status page, array of channels
-	UpstreamBondedChannels   []UpstreamChannel   // From status page, array of channels
+// Exporter exposes the modem scraped by the embedded sb8200.Exporter as
+// Prometheus metrics.
+type Exporter struct {
+	*sb8200.Exporter
+	LockValueType         prometheus.ValueType // Value type the channel lock metric is emitted as
+	LogStaticInfo         bool                 // Log modem metadata instead of exposing sb8200_info
+	ObservationTimestamps bool                 // Stamp cached metrics with the time the modem was scraped
+	HoldLastGood          time.Duration        // How long failed scrapes re-emit the last good values, 0 to disable
+	RawValues             bool                 // Expose the raw strings values were parsed from, for debugging
+	ChannelKey            string               // Label identifying channels, ChannelKeyID or ChannelKeyIndex
+	Maintenance           *MaintenanceMode     // Stops contacting the modem while enabled, nil to never
+	StartupSplay          time.Duration        // Poll waits a random time up to this long before the first scrape
+	RoundPrecision        int                  // Decimals power and SNR are rounded to, negative for no rounding
+	Bands                 []FrequencyBand      // Frequency bands channels are labelled with, lowest first
+	ExpectedSerial        string               // Serial number the modem should report, empty to not check
+	ExpectedMAC           string               // MAC address the modem should report, empty to not check
+	WarmupScrapes         int                  // Successful scrapes after startup whose modem counters are left out
+	ClockTolerance        time.Duration        // Largest clock skew sb8200_clock_in_sync still reports as in sync
+
+	// Modem scraped in place of this one when its scrape fails, nil for none.
+	// Metrics keep this modem's host label whichever modem they came from.
+	Backup *sb8200.Exporter
+
+	// Observes downstream power once per channel per scrape, nil when disabled
+	PowerHistogram *prometheus.HistogramVec
+
+	// Observes the duration of each phase of every scrape, nil when disabled
+	PhaseHistogram *prometheus.HistogramVec
+
+	// Observes each downstream channel's uncorrectable errors since the
+	// previous scrape, nil when disabled
+	UncorrectableHistogram *prometheus.HistogramVec
+
+	// Appends every background scrape to a CSV file, nil when disabled
+	Recorder *Recorder
+
+	mu         sync.Mutex    // Guards the fields below
+	polling    bool          // Whether a background loop is feeding the cache
+	cached     *scrapeResult // Most recent background scrape, nil until the first completes
+	loggedInfo string        // Modem metadata last written to the log
+	mismatched string        // Unexpected identity last warned about, see mismatch
+	panics     float64       // Panics recovered while scraping or collecting
+	emitErrors float64       // Metrics that couldn't be built and were left out
+	relogins   float64       // Sessions re-established in the middle of a scrape
+	scrapes    int           // Successful scrapes so far, for WarmupScrapes
+
+	// Rows dropped for repeating a channel ID, by direction
+	duplicateChannels map[string]float64
+
+	// Successful scrapes by the raw connectivity state they found
+	connectivityStates map[string]float64
+
+	// Event log entries seen by priority, and T3 and T4 timeouts among them
+	// by sb8200.Event.Timeout, see countEvents
+	eventCounts        map[string]float64
+	timeoutEvents      map[int]float64
+	previousEvents     map[sb8200.Event]int // Entries of the previous event log read
+	previousEventsHost string
+
+	// Collections that didn't scrape the modem, by skipReasons
+	skippedScrapes map[string]float64
+
+	subscribers map[chan StreamEvent]struct{} // Receive every scrape result, see subscribe
+
+	// Previous successful scrape, for the per-interval deltas
+	previousCorrected     map[string]float64 // Downstream corrected errors by channel ID
+	previousUncorrectable map[string]float64 // Downstream uncorrectable errors by channel ID
+	previousUptime        float64
+	previousHost          string
+	lastGood              *scrapeResult // Most recent successful scrape
+
+	// Highest OFDM profile seen on each downstream channel by channel ID,
+	// the baseline downshifts are measured against
+	highestProfile map[string]float64
+
+	// When each channel of lockedHost last went from unlocked to locked, by
+	// lockKey. Unlocked channels have no entry.
+	lockedSince map[string]time.Time
+	lockedHost  string
+
+	recentErrors errorRing // Last few scrape errors, served on /debug/errors
 }
 
-type Exporter struct {
-	Host      string // Hostname or network address of SB8200 modem
-	AuthToken string // b64 encoded username:password
+// MaintenanceMode is a switch, usually shared by every Exporter, for planned
+// downtime: while enabled the modem isn't contacted and up is reported as 0
+// alongside sb8200_maintenance so alerts can be silenced.
+type MaintenanceMode struct {
+	enabled int32 // Accessed atomically
+}
+
+func (m *MaintenanceMode) Enabled() bool {
+	return m != nil && atomic.LoadInt32(&m.enabled) == 1
+}
+
+func (m *MaintenanceMode) Set(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.enabled, value)
+}
+
+// scrapeResult is the outcome of a single background scrape.
+type scrapeResult struct {
+	modem    sb8200.ArrisModem
+	err      error
+	time     time.Time
+	duration time.Duration // Spent in Scrape, the backup modem's included
+
+	// Downstream corrected errors since the previous scrape by channel ID,
+	// nil on the first scrape
+	correctedInterval map[string]float64
+
+	// Highest OFDM profile seen so far by channel ID, including this scrape
+	highestProfile map[string]float64
+
+	// Unix time each locked channel locked at by lockKey, see trackLocks
+	lockedSince map[string]float64
+
+	// Last good scrape to re-emit in place of a failed one, nil when none
+	// is recent enough or HoldLastGood is disabled
+	held *scrapeResult
+
+	// Whether this is one of the first WarmupScrapes successful scrapes,
+	// whose modem counters are left out
+	warmup bool
 }
 
 func NewExporter(host string, user string, pass string) *Exporter {
 	return &Exporter{
-		Host:      host,
-		AuthToken: b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, pass))),
+		Exporter:       sb8200.NewExporter(host, user, pass),
+		LockValueType:  prometheus.GaugeValue,
+		ChannelKey:     ChannelKeyID,
+		RoundPrecision: -1,
+		ClockTolerance: 30 * time.Second,
 	}
 }
 
-// Log into the web interface and return sessionID and csrf token
-func (e *Exporter) Login() (sessionID *http.Cookie, csrfToken string, err error) {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/logout.html", e.Host), nil)
-	if err != nil {
-		return
-	}
-	logoutResp, err := client.Do(req)
-	if err != nil {
-		return
+// Poll scrapes the modem immediately, or after StartupSplay, and then once
+// every interval, caching each result for Collect to serve. It blocks until
+// ctx is cancelled.
+func (e *Exporter) Poll(ctx context.Context, interval time.Duration) {
+	e.mu.Lock()
+	e.polling = true
+	e.mu.Unlock()
+
+	// Stagger exporters started together so they don't all hit their
+	// modems (and a shared CMTS) at once.
+	if e.StartupSplay > 0 {
+		splay := time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(int64(e.StartupSplay)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(splay):
+		}
 	}
-	defer logoutResp.Body.Close()
 
-	url := fmt.Sprintf("https://%s/cmconnectionstatus.html?login_%s", e.Host, e.AuthToken)
-	req, err = http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !e.Maintenance.Enabled() {
+			result := e.scrape()
+			e.mu.Lock()
+			e.cached = &result
+			e.mu.Unlock()
+			if e.Recorder != nil {
+				e.Recorder.Record(e.Host, result)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return
+// modem returns the cached background scrape when polling, otherwise it
+// scrapes the modem on demand. fromCache reports which one happened.
+func (e *Exporter) modem() (result scrapeResult, fromCache bool) {
+	e.mu.Lock()
+	polling, cached := e.polling, e.cached
+	e.mu.Unlock()
+
+	if !polling {
+		return e.scrape(), false
+	}
+	if cached == nil {
+		return scrapeResult{err: errors.New("no background scrape has completed yet"), time: time.Now()}, false
 	}
-	defer resp.Body.Close()
+	return *cached, true
+}
 
-	if resp.StatusCode == http.StatusOK {
-		var body []byte
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return
+// scrape scrapes the modem, or the backup modem if that fails, and records
+// the outcome.
+func (e *Exporter) scrape() scrapeResult {
+	start := time.Now()
+	modem, err := e.safeScrape(e.Exporter)
+	if err != nil && e.Backup != nil {
+		log.Warnf("Scraping %s failed, falling back to backup modem %s: %v", e.Host, e.Backup.Host, err)
+		backupModem, backupErr := e.safeScrape(e.Backup)
+		if backupErr == nil {
+			modem, err = backupModem, nil
+		} else {
+			err = fmt.Errorf("%w (backup %s: %v)", err, e.Backup.Host, backupErr)
 		}
-		csrfToken = string(body)
+	}
+	if err == nil {
+		modem = e.dropDuplicateChannels(modem)
+	}
+	now := time.Now()
+	duration := now.Sub(start)
+	e.observe(modem, err)
+	if err != nil {
+		e.recentErrors.add(errorRecord{Time: now, Host: e.Host, Error: err.Error()})
+		result := scrapeResult{modem: modem, err: err, time: now, duration: duration}
+		e.mu.Lock()
+		if e.HoldLastGood > 0 && e.lastGood != nil && now.Sub(e.lastGood.time) <= e.HoldLastGood {
+			result.held = e.lastGood
+		}
+		e.mu.Unlock()
+		e.publish(result)
+		return result
+	}
 
-		for _, cookie := range resp.Cookies() {
-			// The server will set the sessionID to "" whenever it wants to
-			//   force and signal the end of a session.
-			if cookie.Name == "sessionId" && cookie.Value != "" {
-				sessionID = cookie
-				return
+	correctedInterval, uncorrectableInterval := e.errorIntervals(modem)
+	if e.UncorrectableHistogram != nil {
+		histogram := e.UncorrectableHistogram.WithLabelValues(e.Host)
+		for _, delta := range uncorrectableInterval {
+			if !math.IsNaN(delta) {
+				histogram.Observe(delta)
 			}
 		}
-
-		err = errors.New("missing sessionID")
-		return
 	}
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = errors.New("invalid credentials")
-		return
+	result := scrapeResult{
+		modem:             modem,
+		time:              now,
+		duration:          duration,
+		correctedInterval: correctedInterval,
+		highestProfile:    e.trackProfiles(modem),
+		lockedSince:       e.trackLocks(modem, now),
 	}
+	e.mu.Lock()
+	e.scrapes++
+	result.warmup = e.scrapes <= e.WarmupScrapes
+	e.lastGood = &result
+	e.mu.Unlock()
+	e.publish(result)
+	return result
+}
 
-	err = errors.New("unknown error/response code")
+// safeScrape scrapes a modem, turning a panic (e.g. a parser choking on a
+// malformed page) into a failed scrape rather than crashing the exporter.
+// Panics in the goroutines fetching the pages come back as a PanicError.
+func (e *Exporter) safeScrape(scraper *sb8200.Exporter) (modem sb8200.ArrisModem, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.recoverPanic("scraping "+scraper.Host, r, debug.Stack())
+			err = fmt.Errorf("panic while scraping %s: %v", scraper.Host, r)
+		}
+	}()
+	modem, err = scraper.Scrape()
+	var panicErr *sb8200.PanicError
+	if errors.As(err, &panicErr) {
+		e.recoverPanic("scraping "+scraper.Host, panicErr.Value, panicErr.Stack)
+	}
 	return
 }
 
-func ScrapeColStr(element *goquery.Selection, child int) string {
-	selectString := fmt.Sprintf("td:nth-child(%d)", child)
-	return element.Find(selectString).First().Text()
+// recoverPanic logs and counts a panic recovered while doing what, which
+// panicked with stack.
+func (e *Exporter) recoverPanic(what string, r interface{}, stack []byte) {
+	log.Errorf("Recovered from panic while %s: %v\n%s", what, r, stack)
+	e.mu.Lock()
+	e.panics++
+	e.mu.Unlock()
 }
 
-func ScrapeUnitValue(element *goquery.Selection, child int, trim string) (float64, error) {
-	valStr := strings.TrimRight(ScrapeColStr(element, child), trim)
-	valFloat, err := strconv.ParseFloat(valStr, 64)
-	if err != nil {
-		return 0, err
+// dropDuplicateChannels removes rows repeating a channel ID already seen in
+// the same table, keeping the first. Emitting both would yield two series with
+// the same labels and fail the whole /metrics response.
+func (e *Exporter) dropDuplicateChannels(modem sb8200.ArrisModem) sb8200.ArrisModem {
+	duplicates := make(map[string]float64)
+
+	seen := make(map[string]bool)
+	downstream := modem.DownstreamBondedChannels[:0:0]
+	for _, channel := range modem.DownstreamBondedChannels {
+		if seen[channel.ChannelID] {
+			log.Warnf("Dropping duplicate downstream channel %s from %s", channel.ChannelID, modem.Host)
+			duplicates[DOWNSTREAM]++
+			continue
+		}
+		seen[channel.ChannelID] = true
+		downstream = append(downstream, channel)
 	}
-	return valFloat, nil
-}
 
-func ScrapeDownstreamTableRow(element *goquery.Selection) (downstreamChannel DownstreamChannel, err error) {
-	// Skip first row (that shows header values)
-	if ScrapeColStr(element, 1) == "Channel ID" {
-		err = errors.New("skip parsing second header row")
-		return
+	seen = make(map[string]bool)
+	upstream := modem.UpstreamBondedChannels[:0:0]
+	for _, channel := range modem.UpstreamBondedChannels {
+		if seen[channel.ChannelID] {
+			log.Warnf("Dropping duplicate upstream channel %s from %s", channel.ChannelID, modem.Host)
+			duplicates[UPSTREAM]++
+			continue
+		}
+		seen[channel.ChannelID] = true
+		upstream = append(upstream, channel)
 	}
 
-	lockStatus := 0.
-	if ScrapeColStr(element, 2) == "Locked" {
-		lockStatus = 1.
+	modem.DownstreamBondedChannels, modem.UpstreamBondedChannels = downstream, upstream
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.duplicateChannels == nil {
+		e.duplicateChannels = make(map[string]float64)
 	}
+	for direction, count := range duplicates {
+		e.duplicateChannels[direction] += count
+	}
+	return modem
+}
 
-	power, err := ScrapeUnitValue(element, 5, " dBmV")
-	if err != nil {
-		return
+// trackProfiles records the highest OFDM profile seen on each downstream
+// channel and returns a copy of them for this scrape.
+func (e *Exporter) trackProfiles(modem sb8200.ArrisModem) map[string]float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.highestProfile == nil {
+		e.highestProfile = make(map[string]float64)
+	}
+	highest := make(map[string]float64)
+	for _, channel := range modem.DownstreamBondedChannels {
+		if math.IsNaN(channel.ProfileID) {
+			continue
+		}
+		if previous, ok := e.highestProfile[channel.ChannelID]; !ok || channel.ProfileID > previous {
+			e.highestProfile[channel.ChannelID] = channel.ProfileID
+		}
+		highest[channel.ChannelID] = e.highestProfile[channel.ChannelID]
 	}
+	return highest
+}
 
-	snr, err := ScrapeUnitValue(element, 6, " dB")
-	if err != nil {
-		return
+// lockKey identifies a channel across directions.
+func lockKey(direction string, channelID string) string {
+	return direction + "/" + channelID
+}
+
+// trackLocks records when each channel last locked and returns the unix
+// times for this scrape. A channel already locked when first seen counts as
+// locked since that scrape, as does every channel after switching modems.
+func (e *Exporter) trackLocks(modem sb8200.ArrisModem, now time.Time) map[string]float64 {
+	locked := make(map[string]bool)
+	for _, channel := range modem.DownstreamBondedChannels {
+		if channel.LockStatus == 1 {
+			locked[lockKey(DOWNSTREAM, channel.ChannelID)] = true
+		}
+	}
+	for _, channel := range modem.UpstreamBondedChannels {
+		if channel.LockStatus == 1 {
+			locked[lockKey(UPSTREAM, channel.ChannelID)] = true
+		}
 	}
 
-	correctedErrors, err := ScrapeUnitValue(element, 7, "")
-	if err != nil {
-		return
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lockedSince == nil || modem.Host != e.lockedHost {
+		e.lockedSince, e.lockedHost = make(map[string]time.Time), modem.Host
+	}
+	for key := range e.lockedSince {
+		if !locked[key] {
+			delete(e.lockedSince, key)
+		}
 	}
+	since := make(map[string]float64, len(locked))
+	for key := range locked {
+		if _, ok := e.lockedSince[key]; !ok {
+			e.lockedSince[key] = now
+		}
+		since[key] = float64(e.lockedSince[key].UnixNano()) / 1e9
+	}
+	return since
+}
 
-	uncorrectableErrors, err := ScrapeUnitValue(element, 8, "")
-	if err != nil {
-		return
+// errorIntervals returns the corrected and uncorrectable errors of each
+// downstream channel since the previous scrape, nil on the first scrape.
+func (e *Exporter) errorIntervals(modem sb8200.ArrisModem) (corrected map[string]float64, uncorrectable map[string]float64) {
+	currentCorrected := make(map[string]float64, len(modem.DownstreamBondedChannels))
+	currentUncorrectable := make(map[string]float64, len(modem.DownstreamBondedChannels))
+	for _, channel := range modem.DownstreamBondedChannels {
+		currentCorrected[channel.ChannelID] = channel.CorrectedErrors
+		currentUncorrectable[channel.ChannelID] = channel.UncorrectableErrors
 	}
 
-	downstreamChannel = DownstreamChannel{
-		ChannelID:           ScrapeColStr(element, 1),
-		LockStatus:          lockStatus,
-		Modulation:          ScrapeColStr(element, 3),
-		Frequency:           ScrapeColStr(element, 4),
-		Power:               power,
-		SNR:                 snr,
-		CorrectedErrors:     correctedErrors,
-		UncorrectableErrors: uncorrectableErrors,
+	e.mu.Lock()
+	previousCorrected, previousUncorrectable := e.previousCorrected, e.previousUncorrectable
+	previousUptime, previousHost := e.previousUptime, e.previousHost
+	e.previousCorrected, e.previousUncorrectable = currentCorrected, currentUncorrectable
+	e.previousHost = modem.Host
+	if !math.IsNaN(modem.Uptime) {
+		e.previousUptime = modem.Uptime
 	}
-	return
+	e.mu.Unlock()
+
+	// Counters of a different modem (e.g. after failing over to the backup)
+	// can't be compared.
+	if previousCorrected == nil || modem.Host != previousHost {
+		return nil, nil
+	}
+	rebooted := modem.Uptime < previousUptime
+	return counterInterval(currentCorrected, previousCorrected, rebooted),
+		counterInterval(currentUncorrectable, previousUncorrectable, rebooted)
 }
 
-func ScrapeDownstreamTable(element *goquery.Selection) (downstreamChannels []DownstreamChannel) {
-	element.Each(func(index int, element *goquery.Selection) {
-		parsedRow, err := ScrapeDownstreamTableRow(element)
-		if err != nil {
-			log.Debug(err)
-			return
+// counterInterval returns how much each counter grew since previous. The
+// modem resets its counters on reboot, so a counter that went backwards, or
+// any counter when the modem rebooted, reports 0 rather than a negative or
+// bogus delta. Counters missing from previous are left out.
+func counterInterval(current map[string]float64, previous map[string]float64, rebooted bool) map[string]float64 {
+	interval := make(map[string]float64, len(current))
+	for id, count := range current {
+		last, ok := previous[id]
+		if !ok {
+			continue
 		}
-		downstreamChannels = append(downstreamChannels, parsedRow)
-	})
-	return
+		if rebooted || count < last {
+			interval[id] = 0
+			continue
+		}
+		interval[id] = count - last
+	}
+	return interval
 }
 
-func ScrapeUpstreamTableRow(element *goquery.Selection) (upstreamChannel UpstreamChannel, err error) {
-	// Skip first row (that shows header values)
-	if firstVal := ScrapeColStr(element, 1); firstVal == "Channel" || firstVal == "" {
-		err = errors.New("skip first two header row")
-		return
+// observe records a fresh scrape in the histograms. It is called once per
+// scrape rather than from Collect so that serving the same cached scrape
+// several times doesn't skew the distributions.
+func (e *Exporter) observe(modem sb8200.ArrisModem, err error) {
+	// Failed scrapes too, a slow login is often why they failed
+	if e.PhaseHistogram != nil {
+		for phase, duration := range map[string]time.Duration{
+			"login":        modem.Phases.Login,
+			"fetch_status": modem.Phases.FetchStatus,
+			"parse_status": modem.Phases.ParseStatus,
+			"fetch_info":   modem.Phases.FetchInfo,
+			"parse_info":   modem.Phases.ParseInfo,
+		} {
+			if duration > 0 {
+				e.PhaseHistogram.WithLabelValues(e.Host, phase).Observe(duration.Seconds())
+			}
+		}
 	}
-
-	lockStatus := 0.
-	if ScrapeColStr(element, 3) == "Locked" {
-		lockStatus = 1.
+	if modem.Relogins > 0 {
+		e.mu.Lock()
+		e.relogins += float64(modem.Relogins)
+		e.mu.Unlock()
 	}
-
-	power, err := ScrapeUnitValue(element, 7, " dBmV")
 	if err != nil {
 		return
 	}
-
-	upstreamChannel = UpstreamChannel{
-		Channel:       ScrapeColStr(element, 1),
-		ChannelID:     ScrapeColStr(element, 2),
-		LockStatus:    lockStatus,
-		USChannelType: ScrapeColStr(element, 4),
-		Frequency:     ScrapeColStr(element, 5),
-		Width:         ScrapeColStr(element, 6),
-		Power:         power,
+	if modem.RawConnectivityState != "" {
+		e.mu.Lock()
+		if e.connectivityStates == nil {
+			e.connectivityStates = make(map[string]float64)
+		}
+		e.connectivityStates[modem.RawConnectivityState]++
+		e.mu.Unlock()
+	}
+	if modem.EventLog != nil {
+		e.countEvents(modem)
+	}
+	if e.PowerHistogram != nil {
+		histogram := e.PowerHistogram.WithLabelValues(e.Host)
+		for _, channel := range modem.DownstreamBondedChannels {
+			histogram.Observe(channel.Power)
+		}
 	}
-	return
 }
 
-func ScrapeUpstreamTable(element *goquery.Selection) (upstreamChannels []UpstreamChannel) {
-	element.Each(func(index int, element *goquery.Selection) {
-		parsedRow, err := ScrapeUpstreamTableRow(element)
-		if err != nil {
-			log.Debug(err)
-			return
+// countEvents adds the entries of the event log that weren't in the previous
+// read of it to eventCounts. The log is a rolling buffer the modem clears on
+// reboot, so entries are told apart by their contents rather than position.
+func (e *Exporter) countEvents(modem sb8200.ArrisModem) {
+	current := make(map[sb8200.Event]int, len(modem.EventLog.Events))
+	for _, event := range modem.EventLog.Events {
+		current[event]++
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if modem.Host != e.previousEventsHost {
+		e.previousEvents = nil
+	}
+	if e.eventCounts == nil {
+		e.eventCounts = make(map[string]float64)
+		e.timeoutEvents = map[int]float64{3: 0, 4: 0}
+	}
+	for event, count := range current {
+		if added := count - e.previousEvents[event]; added > 0 {
+			priority := event.Priority
+			if priority == "" {
+				priority = "unknown"
+			}
+			e.eventCounts[priority] += float64(added)
+			if timeout := event.Timeout(); timeout != 0 {
+				e.timeoutEvents[timeout] += float64(added)
+			}
 		}
-		upstreamChannels = append(upstreamChannels, parsedRow)
-	})
-	return
+	}
+	e.previousEvents, e.previousEventsHost = current, modem.Host
 }
 
-func GetURL(url string, sessionID *http.Cookie) (document *goquery.Document, err error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
+// logInfo logs the modem metadata normally carried by sb8200_info. It only
+// logs on the first scrape and when the metadata changes (e.g. a firmware
+// upgrade) to keep the static labels out of every /metrics response.
+func (e *Exporter) logInfo(modem sb8200.ArrisModem) {
+	info := fmt.Sprintf("host=%s hwversion=%s swversion=%s mac=%s serial=%s",
+		e.Host, modem.HardwareVersion, modem.SoftwareVersion,
+		modem.MACAddress, modem.SerialNumber)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if info == e.loggedInfo {
 		return
 	}
-	req.AddCookie(sessionID)
+	e.loggedInfo = info
+	log.Infof("Modem info: %s", info)
+}
+
+// mismatch reports whether the modem isn't the one ExpectedSerial and
+// ExpectedMAC describe, e.g. after the ISP swapped it. It warns once per
+// unexpected identity rather than on every scrape.
+func (e *Exporter) mismatch(modem sb8200.ArrisModem) bool {
+	serialMismatch := e.ExpectedSerial != "" && !strings.EqualFold(strings.TrimSpace(modem.SerialNumber), strings.TrimSpace(e.ExpectedSerial))
+	macMismatch := e.ExpectedMAC != "" && normalizeMAC(modem.MACAddress) != normalizeMAC(e.ExpectedMAC)
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	identity := ""
+	if serialMismatch || macMismatch {
+		identity = fmt.Sprintf("serial=%s mac=%s", modem.SerialNumber, modem.MACAddress)
 	}
-	client := &http.Client{Transport: tr}
-	resp, err := client.Do(req)
-	if err != nil {
-		return
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if identity != e.mismatched && identity != "" {
+		log.Warnf("Modem %s reports %s, expected serial=%s mac=%s, has it been replaced?",
+			e.Host, identity, e.ExpectedSerial, e.ExpectedMAC)
 	}
-	defer resp.Body.Close()
+	e.mismatched = identity
+	return identity != ""
+}
 
-	document, err = goquery.NewDocumentFromReader(resp.Body)
-	return
+// normalizeMAC drops the separators and case of a MAC address so that
+// "AA-BB-CC-DD-EE-FF" matches "aa:bb:cc:dd:ee:ff".
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac)))
 }
 
-// Scrape the web page for metric data
-func (e *Exporter) Scrape() (modem ArrisModem, err error) {
-	sessionID, csrfToken, err := e.Login()
+const (
+	namespace  = "sb8200"
+	DOWNSTREAM = "downstream"
+	UPSTREAM   = "upstream"
+
+	// Modulation classes
+	SCQAM = "scqam"
+	OFDM  = "ofdm"
+	OFDMA = "ofdma"
+)
+
+// modulationClass classifies a channel as SC-QAM or OFDM/OFDMA from its
+// modulation string. The SB8200 reports OFDM downstream channels as "Other".
+func modulationClass(direction string, modulation string) string {
+	modulation = strings.ToLower(modulation)
+	if !strings.Contains(modulation, "ofdm") && !(direction == DOWNSTREAM && modulation == "other") {
+		return SCQAM
+	}
+	if direction == UPSTREAM {
+		return OFDMA
+	}
+	return OFDM
+}
+
+// frequencyMHz rounds a channel frequency to the nearest MHz for grouping,
+// or returns "" when it can't be parsed.
+func frequencyMHz(frequency string) string {
+	hz, err := sb8200.ParseFrequency(frequency)
 	if err != nil {
-		log.Error("Failed to fetch login tokens")
-		return
+		return ""
 	}
+	return strconv.FormatFloat(math.Round(hz/1e6), 'f', 0, 64)
+}
+
+// FrequencyBand is a named range of channel frequencies, from the upper
+// bound of the previous band up to UpperMHz.
+type FrequencyBand struct {
+	Name     string
+	UpperMHz float64 // Exclusive, +Inf for the last band
+}
 
-	url := fmt.Sprintf("https://%s/cmconnectionstatus.html?ct_%s", e.Host, csrfToken)
-	document, err := GetURL(url, sessionID)
+// band returns the name of the band a channel frequency falls in, "" when
+// the frequency can't be parsed or no bands are configured.
+func (e *Exporter) band(frequency string) string {
+	hz, err := sb8200.ParseFrequency(frequency)
 	if err != nil {
-		log.Error("Failed to fetch connection status url")
-		return
+		return ""
+	}
+	for _, band := range e.Bands {
+		if hz/1e6 < band.UpperMHz {
+			return band.Name
+		}
 	}
+	return ""
+}
 
-	connectivityStateSelector := ".content > center:nth-child(2) > table:nth-child(1) > tbody:nth-child(1) > tr:nth-child(4) > td:nth-child(2)"
-	connectivityState := 0.
-	if document.Find(connectivityStateSelector).First().Text() == "OK" {
-		connectivityState = 1.
+// round rounds a power or SNR reading to RoundPrecision decimals.
+func (e *Exporter) round(value float64) float64 {
+	if e.RoundPrecision < 0 {
+		return value
 	}
+	scale := math.Pow(10, float64(e.RoundPrecision))
+	return math.Round(value*scale) / scale
+}
 
-	var downstreamChannels []DownstreamChannel
-	var upstreamChannels []UpstreamChannel
-	document.Find("table").Each(func(i int, element *goquery.Selection) {
-		switch i {
-		case 1:
-			downstreamChannels = ScrapeDownstreamTable(element.Find("tr"))
-		case 2:
-			upstreamChannels = ScrapeUpstreamTable(element.Find("tr"))
-		}
-	})
+// addReported adds value to sum, treating NaN as not reported: the sum stays
+// NaN until the first reported value.
+func addReported(sum float64, value float64) float64 {
+	switch {
+	case math.IsNaN(value):
+		return sum
+	case math.IsNaN(sum):
+		return value
+	}
+	return sum + value
+}
 
-	url = fmt.Sprintf("https://%s/cmswinfo.html?ct_%s", e.Host, csrfToken)
-	document, err = GetURL(url, sessionID)
-	if err != nil {
-		log.Error("Failed to fetch product information page")
-		return
+// median returns the median of values, or NaN when there are none.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
 	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// Values of Exporter.ChannelKey
+const (
+	ChannelKeyID    = "id"
+	ChannelKeyIndex = "index"
+)
 
-	hwVerSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(3) > td:nth-child(2)"
-	hwVersion := document.Find(hwVerSelector).First().Text()
+// channelDesc holds a per-channel metric descriptor for each ChannelKey,
+// labelled with channel_id or channel_index respectively.
+type channelDesc map[string]*prometheus.Desc
 
-	swVerSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(4) > td:nth-child(2)"
-	swVersion := document.Find(swVerSelector).First().Text()
+// descBuilder builds descriptors with either their terse help or, when
+// verbose, the longer help from verboseHelpText spelling out units and ranges.
+type descBuilder struct {
+	verbose bool
+}
 
-	macAddrSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(5) > td:nth-child(2)"
-	macAddress := document.Find(macAddrSelector).First().Text()
+func (b descBuilder) help(fqName string, help string) string {
+	if verbose, ok := verboseHelpText[fqName]; ok && b.verbose {
+		return verbose
+	}
+	return help
+}
 
-	serialSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(6) > td:nth-child(2)"
-	serial := document.Find(serialSelector).First().Text()
+func (b descBuilder) newDesc(fqName string, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(fqName, b.help(fqName, help), variableLabels, constLabels)
+}
 
-	uptimeSelector := "table.simpleTable:nth-child(5) > tbody:nth-child(1) > tr:nth-child(2) > td:nth-child(2)"
-	// uptimeStr will look like: 40 days 05h:32m:52s.00
-	uptimeStr := document.Find(uptimeSelector).First().Text()
-	// parts will look like ["40" "05" "32" "52" "00"]
-	uptimeParts := regexp.MustCompile(`\D+`).Split(uptimeStr, -1)
-	uptime := 0.
-	for i, nStr := range uptimeParts {
-		var n float64
-		n, err = strconv.ParseFloat(nStr, 64)
-		if err != nil {
-			return
-		}
-		switch i {
-		case 0: // days
-			uptime = n
-		case 1: // hours
-			uptime = uptime*24 + n
-		case 2: // minutes
-			uptime = uptime*60 + n
-		case 3: // seconds
-			uptime = uptime*60 + n
-		} // ignore milliseconds
-	}
-
-	modem = ArrisModem{
-		Host:                     e.Host,
-		ConnectivityState:        connectivityState,
-		Uptime:                   uptime,
-		HardwareVersion:          hwVersion,
-		SoftwareVersion:          swVersion,
-		MACAddress:               macAddress,
-		SerialNumber:             serial,
-		DownstreamBondedChannels: downstreamChannels,
-		UpstreamBondedChannels:   upstreamChannels,
+func (b descBuilder) newChannelDesc(subsystem string, name string, help string, labels ...string) channelDesc {
+	fqName := prometheus.BuildFQName(namespace, subsystem, name)
+	return channelDesc{
+		ChannelKeyID:    b.newDesc(fqName, help, append([]string{"host", "channel_id"}, labels...), nil),
+		ChannelKeyIndex: b.newDesc(fqName, help, append([]string{"host", "channel_index"}, labels...), nil),
 	}
-	return
 }
 
-const (
-	namespace  = "sb8200"
-	DOWNSTREAM = "downstream"
-	UPSTREAM   = "upstream"
-)
+// verboseHelpText is the help used by -metrics.verbose-help, by metric name.
+// Metrics without an entry keep their terse help.
+var verboseHelpText = map[string]string{
+	"sb8200_up":                               "1 if the last scrape of the modem succeeded, 0 if it failed",
+	"sb8200_connect_duration_seconds":         "Seconds spent on DNS, TCP and TLS setup across all requests of the last scrape",
+	"sb8200_transfer_duration_seconds":        "Seconds spent waiting for and reading responses across all requests of the last scrape",
+	"sb8200_parse_duration_seconds":           "Seconds spent parsing the modem's pages in the last scrape, excluding network time",
+	"sb8200_active_modem":                     "1 for the modem of a primary/backup pair, named by the active label, that metrics were last scraped from, 0 for the other",
+	"sb8200_maintenance":                      "1 while the exporter is in maintenance mode and doesn't contact the modem, 0 otherwise",
+	"sb8200_data_stale":                       "1 if the modem metrics are held over from an earlier scrape because the last one failed, 0 if they are fresh",
+	"sb8200_served_from_cache":                "1 if this response used the cached background scrape, 0 if the modem was scraped on demand",
+	"sb8200_connected":                        "1 if the modem reports its connectivity state as OK, 0 otherwise",
+	"sb8200_config_file_ok":                   "1 if the modem reports its configuration file as OK, 0 otherwise",
+	"sb8200_modem_memory_free_bytes":          "Free memory in bytes reported by the modem's diagnostics page",
+	"sb8200_modem_cpu_load":                   "CPU load in percent (0-100) reported by the modem's diagnostics page",
+	"sb8200_startup_step":                     "1 if this step of the modem's startup procedure succeeded, 0 otherwise",
+	"sb8200_lock_threshold_dbmv":              "Power in dBmV a channel must reach for the modem to lock it",
+	"sb8200_lock_threshold_db":                "SNR in dB a channel must reach for the modem to lock it",
+	"sb8200_uptime_seconds":                   "Seconds since the modem last booted, resets to 0 on reboot",
+	"sb8200_channel_lock":                     "1 if the channel is locked, 0 otherwise",
+	"sb8200_channel_power":                    "Channel power level in dBmV. Downstream is usually within -7 to +7 dBmV, upstream within 35 to 51 dBmV",
+	"sb8200_channel_power_deviation_dbmv":     "Channel power level in dBmV minus the median power of the channel's table, 0 for a perfectly flat table",
+	"sb8200_channel_snr":                      "Channel SNR/MER in dB. Downstream SC-QAM channels usually need 33 dB or more",
+	"sb8200_channel_corrected_total":          "Codewords with errors corrected by FEC since the modem booted, resets to 0 on reboot",
+	"sb8200_channel_corrected_interval":       "Codewords with errors corrected by FEC since the previous scrape, 0 across modem reboots",
+	"sb8200_channel_uncorrectable_total":      "Codewords with errors FEC couldn't correct since the modem booted, resets to 0 on reboot",
+	"sb8200_ofdm_profile_downshifted":         "1 if the channel is on a lower OFDM profile than the highest seen on it since the exporter started, 0 otherwise",
+	"sb8200_channel_lock_ratio":               "Fraction (0-1) of bonded channels that are locked",
+	"sb8200_uncorrectable_total_all_channels": "Codewords with errors FEC couldn't correct summed across all downstream channels since the modem booted, resets to 0 on reboot",
+}
+
+// channelKey returns the label value identifying a channel: its ID, or its
+// 1-based position in the modem's table.
+func (e *Exporter) channelKey(index int, channelID string) string {
+	if e.ChannelKey == ChannelKeyIndex {
+		return strconv.Itoa(index + 1)
+	}
+	return channelID
+}
 
 var (
-	// Metrics
-	upMetric = prometheus.NewDesc(
+	// Histograms, registered separately since they track state across scrapes
+	downstreamPowerHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: DOWNSTREAM,
+			Name:      "power_distribution",
+			Help:      "Distribution of downstream channel power levels (dBmV), observed once per channel per scrape",
+			Buckets:   prometheus.LinearBuckets(-15, 2.5, 13),
+		},
+		[]string{"host"},
+	)
+	uncorrectableDeltaHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "uncorrectable_delta_distribution",
+			Help:      "Distribution of uncorrectable errors per downstream channel between consecutive scrapes, observed once per channel per scrape",
+			Buckets:   append([]float64{0}, prometheus.ExponentialBuckets(1, 4, 10)...),
+		},
+		[]string{"host"},
+	)
+	scrapePhaseHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "scrape_phase_duration_seconds",
+			Help:      "Time spent in each phase of a scrape: login, fetch_status, parse_status, fetch_info and parse_info",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"host", "phase"},
+	)
+
+	// Metric descriptors, see buildDescriptors
+	upMetric                          *prometheus.Desc
+	scrapeErrorMetric                 *prometheus.Desc
+	scrapeDurationMetric              *prometheus.Desc
+	connectDurationMetric             *prometheus.Desc
+	transferDurationMetric            *prometheus.Desc
+	requestsMetric                    *prometheus.Desc
+	parseDurationMetric               *prometheus.Desc
+	activeModemMetric                 *prometheus.Desc
+	maintenanceMetric                 *prometheus.Desc
+	panicsMetric                      *prometheus.Desc
+	reloginsMetric                    *prometheus.Desc
+	emitErrorsMetric                  *prometheus.Desc
+	duplicateChannelsMetric           *prometheus.Desc
+	dataStaleMetric                   *prometheus.Desc
+	servedFromCacheMetric             *prometheus.Desc
+	scrapesSkippedMetric              *prometheus.Desc
+	cacheAgeMetric                    *prometheus.Desc
+	endpointInfoMetric                *prometheus.Desc
+	connectedMetric                   *prometheus.Desc
+	connectivityStatesMetric          *prometheus.Desc
+	configFileOKMetric                *prometheus.Desc
+	eventLogMetric                    *prometheus.Desc
+	eventLogLatestMetric              *prometheus.Desc
+	memoryFreeMetric                  *prometheus.Desc
+	cpuLoadMetric                     *prometheus.Desc
+	spectrumAnalysisMetric            *prometheus.Desc
+	spectrumPowerMetric               *prometheus.Desc
+	startupStepMetric                 *prometheus.Desc
+	lockThresholdPowerMetric          *prometheus.Desc
+	lockThresholdSNRMetric            *prometheus.Desc
+	maxDownstreamChannelsMetric       *prometheus.Desc
+	maxUpstreamChannelsMetric         *prometheus.Desc
+	downstreamChannelCountMetric      *prometheus.Desc
+	upstreamChannelCountMetric        *prometheus.Desc
+	lockedChannelCountMetric          *prometheus.Desc
+	uptimeMetric                      *prometheus.Desc
+	clockSkewMetric                   *prometheus.Desc
+	partialMetric                     *prometheus.Desc
+	clockInSyncMetric                 *prometheus.Desc
+	infoMetric                        *prometheus.Desc
+	modemMismatchMetric               *prometheus.Desc
+	t3TimeoutsMetric                  *prometheus.Desc
+	t4TimeoutsMetric                  *prometheus.Desc
+	channelLockRatioMetric            *prometheus.Desc
+	uncorrectableAllChannelsMetric    *prometheus.Desc
+	docsisVersionMetric               *prometheus.Desc
+	firmwareFamilyMetric              *prometheus.Desc
+	tableIncompleteMetric             *prometheus.Desc
+	dataCompletenessMetric            *prometheus.Desc
+	channelsMetric                    *prometheus.Desc
+	downstreamModulationVarietyMetric *prometheus.Desc
+
+	channelLockMetric              channelDesc
+	channelPowerMetric             channelDesc
+	channelPowerDeviationMetric    channelDesc
+	channelSNRMetric               channelDesc
+	channelFrequencyMetric         channelDesc
+	channelWidthMetric             channelDesc
+	channelRawPowerMetric          channelDesc
+	channelCorrectedMetric         channelDesc
+	channelCorrectedIntervalMetric channelDesc
+	channelUncorrectableMetric     channelDesc
+	ofdmProfileMetric              channelDesc
+	ofdmProfileDownshiftedMetric   channelDesc
+	channelInfoMetric              channelDesc
+	channelLockedSinceMetric       channelDesc
+)
+
+func init() {
+	buildDescriptors(false)
+}
+
+// buildDescriptors (re)builds the metric descriptors. It runs once at init
+// with the terse help strings and again, before the exporters are
+// registered, when main asks for the verbose ones.
+func buildDescriptors(verbose bool) {
+	b := descBuilder{verbose: verbose}
+	upMetric = b.newDesc(
 		prometheus.BuildFQName(namespace, "", "up"),
 		"Was the last data scrape successful?",
 		[]string{"host"}, nil,
 	)
-	connectedMetric = prometheus.NewDesc(
+	scrapeErrorMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "scrape_error"),
+		"Did the last data scrape fail in this stage? One of login, fetch_status, fetch_swinfo, parse_uptime or other",
+		[]string{"host", "error"}, nil,
+	)
+	scrapeDurationMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "scrape_duration_seconds"),
+		"Time the last scrape of the modem took, login and any backup modem included, whether it succeeded or not",
+		[]string{"host"}, nil,
+	)
+	connectDurationMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "connect_duration_seconds"),
+		"Time spent on DNS, TCP and TLS setup across all requests of the last scrape",
+		[]string{"host"}, nil,
+	)
+	transferDurationMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "transfer_duration_seconds"),
+		"Time spent waiting for and reading responses across all requests of the last scrape",
+		[]string{"host"}, nil,
+	)
+	requestsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "modem_requests_per_scrape"),
+		"HTTP requests made to the modem by the last scrape, including login, logout and retries",
+		[]string{"host"}, nil,
+	)
+	parseDurationMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "parse_duration_seconds"),
+		"Time spent parsing the modem's pages in the last scrape, excluding network time",
+		[]string{"host"}, nil,
+	)
+	activeModemMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "active_modem"),
+		"Which modem of the pair metrics were last scraped from, primary or backup. Only exposed when a backup modem is configured",
+		[]string{"host", "active"}, nil,
+	)
+	maintenanceMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "maintenance"),
+		"Is the exporter in maintenance mode, reporting up 0 without contacting the modem?",
+		[]string{"host"}, nil,
+	)
+	panicsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "panics_recovered_total"),
+		"Panics recovered while scraping the modem or collecting its metrics",
+		[]string{"host"}, nil,
+	)
+	reloginsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "midscrape_relogins_total"),
+		"Times a scrape had to log in again because the modem stopped accepting its session between page fetches",
+		[]string{"host"}, nil,
+	)
+	emitErrorsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "metric_emit_errors_total"),
+		"Metrics left out of a collection because they couldn't be built",
+		[]string{"host"}, nil,
+	)
+	duplicateChannelsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "duplicate_channels_total"),
+		"Channel rows dropped for repeating a channel ID already in the same table",
+		[]string{"host", "type"}, nil,
+	)
+	dataStaleMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "data_stale"),
+		"Are the modem metrics held over from an earlier scrape because the last one failed?",
+		[]string{"host"}, nil,
+	)
+	servedFromCacheMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "served_from_cache"),
+		"Did this response use cached data rather than a fresh scrape?",
+		[]string{"host"}, nil,
+	)
+	scrapesSkippedMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "scrapes_skipped_total"),
+		"Collections that didn't scrape the modem, by reason",
+		[]string{"host", "reason"}, nil,
+	)
+	cacheAgeMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "cache_age_seconds"),
+		"Age of the background scrape this response was served from, only exposed while polling",
+		[]string{"host"}, nil,
+	)
+	endpointInfoMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "modem", "endpoint_info"),
+		"Scheme, host and port requests to the modem are sent to",
+		[]string{"host", "scheme", "address", "port"}, nil,
+	)
+	connectedMetric = b.newDesc(
 		prometheus.BuildFQName(namespace, "", "connected"),
 		"Is the modem's connection up (connectivity state)?",
 		[]string{"host"}, nil,
 	)
-	uptimeMetric = prometheus.NewDesc(
+	connectivityStatesMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "connectivity_state_observations_total"),
+		"Scrapes that found the modem in each connectivity state, labelled with the state as shown by the modem",
+		[]string{"host", "state"}, nil,
+	)
+	configFileOKMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "config_file_ok"),
+		"Was the modem's configuration file accepted?",
+		[]string{"host"}, nil,
+	)
+	eventLogMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "event_log_total"),
+		"Entries seen in the modem's event log, by event level as shown by the modem",
+		[]string{"host", "priority"}, nil,
+	)
+	eventLogLatestMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "event_log_last_event_timestamp_seconds"),
+		"Time of the most recent entry in the modem's event log with a time set",
+		[]string{"host"}, nil,
+	)
+	memoryFreeMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "modem", "memory_free_bytes"),
+		"Free memory reported by the modem's diagnostics page",
+		[]string{"host"}, nil,
+	)
+	cpuLoadMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "modem", "cpu_load"),
+		"CPU load (%) reported by the modem's diagnostics page",
+		[]string{"host"}, nil,
+	)
+	spectrumAnalysisMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "spectrum_analysis_enabled"),
+		"Is spectrum analysis enabled on the modem's diagnostics page?",
+		[]string{"host"}, nil,
+	)
+	spectrumPowerMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "spectrum_power_dbmv"),
+		"Power level (dBmV) of a spectrum analysis sample by center frequency (Hz)",
+		[]string{"host", "frequency"}, nil,
+	)
+	startupStepMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "startup_step"),
+		"Did this step of the modem's startup procedure succeed?",
+		[]string{"host", "step"}, nil,
+	)
+	lockThresholdPowerMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "lock_threshold_dbmv"),
+		"Power threshold (dBmV) reported by the modem for locking channels",
+		[]string{"host", "threshold"}, nil,
+	)
+	lockThresholdSNRMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "lock_threshold_db"),
+		"SNR threshold (dB) reported by the modem for locking channels",
+		[]string{"host", "threshold"}, nil,
+	)
+	maxDownstreamChannelsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "max_downstream_channels"),
+		"Maximum number of downstream channels the modem can bond",
+		[]string{"host"}, nil,
+	)
+	maxUpstreamChannelsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "max_upstream_channels"),
+		"Maximum number of upstream channels the modem can bond",
+		[]string{"host"}, nil,
+	)
+	downstreamChannelCountMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "downstream_channel_count"),
+		"Number of downstream channels listed by the modem",
+		[]string{"host"}, nil,
+	)
+	upstreamChannelCountMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "upstream_channel_count"),
+		"Number of upstream channels listed by the modem",
+		[]string{"host"}, nil,
+	)
+	lockedChannelCountMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "locked_channel_count"),
+		"Number of channels the modem reports as locked",
+		[]string{"host", "type"}, nil,
+	)
+	uptimeMetric = b.newDesc(
 		prometheus.BuildFQName(namespace, "", "uptime_seconds"),
 		"Uptime",
 		[]string{"host"}, nil,
 	)
-	infoMetric = prometheus.NewDesc(
+	partialMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "scrape_partial"),
+		"Did the last scrape leave out the product information page because it exceeded -modem.request-timeout?",
+		[]string{"host"}, nil,
+	)
+	clockSkewMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "clock_skew_seconds"),
+		"Modem clock minus the exporter's clock at the time of the scrape",
+		[]string{"host"}, nil,
+	)
+	clockInSyncMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "clock_in_sync"),
+		"Is the modem clock within -modem.clock-tolerance of the exporter's?",
+		[]string{"host"}, nil,
+	)
+	infoMetric = b.newDesc(
 		prometheus.BuildFQName(namespace, "", "info"),
 		"Metadata about this modem.",
 		[]string{"host", "hwversion", "swversion", "mac", "serial"},
 		nil,
 	)
-	channelLockMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "lock"),
+	modemMismatchMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "modem_mismatch"),
+		"Whether the modem reports a different serial number or MAC address than expected, only exposed when one is configured",
+		[]string{"host"}, nil,
+	)
+	channelLockMetric = b.newChannelDesc(
+		"channel", "lock",
 		"Is the downstream channel locked?",
-		[]string{"host", "channel_id", "type"}, nil,
+		"type",
 	)
-	channelPowerMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "power"),
+	channelLockedSinceMetric = b.newChannelDesc(
+		"channel", "locked_since_seconds",
+		"Unix time the channel last went from unlocked to locked, or was first seen locked by the exporter",
+		"type",
+	)
+	channelPowerMetric = b.newChannelDesc(
+		"channel", "power",
 		"Power level (dBmV)",
-		[]string{"host", "channel_id", "type"}, nil,
+		"type",
+	)
+	channelPowerDeviationMetric = b.newChannelDesc(
+		"channel", "power_deviation_dbmv",
+		"Power level (dBmV) relative to the median of the channel's table",
+		"type",
+	)
+	channelFrequencyMetric = b.newChannelDesc(
+		"channel", "frequency_hz",
+		"Frequency the channel operates on (Hz)",
+		"type",
+	)
+	channelWidthMetric = b.newChannelDesc(
+		"channel", "width_hz",
+		"Width of the upstream channel (Hz)",
+		"type",
 	)
-	channelSNRMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "snr"),
+	channelSNRMetric = b.newChannelDesc(
+		"channel", "snr",
 		"SNR/MER rate (dB)",
-		[]string{"host", "channel_id", "type"}, nil,
+		"type",
 	)
-	channelCorrectedMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "corrected_total"),
+	channelRawPowerMetric = b.newChannelDesc(
+		"channel", "raw_power_parsed",
+		"Parsed power level (dBmV), labelled with the raw value shown by the modem. Debugging aid",
+		"type", "raw",
+	)
+	channelCorrectedMetric = b.newChannelDesc(
+		"channel", "corrected_total",
 		"Corrected errors, counter resets to 0 on modem reboot",
-		[]string{"host", "channel_id", "type"}, nil,
+		"type",
+	)
+	channelCorrectedIntervalMetric = b.newChannelDesc(
+		"channel", "corrected_interval",
+		"Corrected errors since the previous scrape, 0 across modem reboots",
+		"type",
 	)
-	channelUncorrectableMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "uncorrectable_total"),
+	channelUncorrectableMetric = b.newChannelDesc(
+		"channel", "uncorrectable_total",
 		"Uncorrectable errors, counter resets to 0 on modem reboot",
-		[]string{"host", "channel_id", "type"}, nil,
+		"type",
+	)
+	ofdmProfileMetric = b.newChannelDesc(
+		"ofdm", "profile_id",
+		"Active OFDM profile of the downstream channel, labelled with the profile as shown by the modem (e.g. 4096QAM)",
+		"type", "profile",
+	)
+	ofdmProfileDownshiftedMetric = b.newChannelDesc(
+		"ofdm", "profile_downshifted",
+		"Is the channel on a lower profile than the highest one seen on it since the exporter started?",
+		"type", "profile",
 	)
-	channelInfoMetric = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "channel", "info"),
+	channelInfoMetric = b.newChannelDesc(
+		"channel", "info",
 		"Channel metadata",
-		[]string{"host", "channel_id", "modulation", "frequency", "width", "type"}, nil,
+		"modulation", "frequency", "frequency_mhz", "band", "width", "type",
 	)
-)
-
+	t3TimeoutsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "t3_timeouts_total"),
+		"T3 (ranging request) timeouts summed across upstream channels, or else counted from the event log, only exposed when the modem reports either",
+		[]string{"host"}, nil,
+	)
+	t4TimeoutsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "t4_timeouts_total"),
+		"T4 (station maintenance) timeouts summed across upstream channels, or else counted from the event log, only exposed when the modem reports either",
+		[]string{"host"}, nil,
+	)
+	channelLockRatioMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "channel", "lock_ratio"),
+		"Fraction of bonded channels that are locked",
+		[]string{"host", "type"}, nil,
+	)
+	uncorrectableAllChannelsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "uncorrectable_total_all_channels"),
+		"Uncorrectable errors summed across all downstream channels, counter resets to 0 on modem reboot",
+		[]string{"host"}, nil,
+	)
+	firmwareFamilyMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "firmware_family"),
+		"Page layout the modem's status was parsed with",
+		[]string{"host", "family"}, nil,
+	)
+	tableIncompleteMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "table_incomplete"),
+		"Whether a status page table had fewer rows than the channel count in its title",
+		[]string{"host", "table"}, nil,
+	)
+	dataCompletenessMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "data_completeness_ratio"),
+		"Fraction of the fields every firmware reports that the last scrape populated, below 1 when tables or selectors came back empty",
+		[]string{"host"}, nil,
+	)
+	docsisVersionMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "docsis_version_info"),
+		"Active DOCSIS version, either reported by the modem or inferred from the presence of OFDM channels",
+		[]string{"host", "version", "source"}, nil,
+	)
+	channelsMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, "", "channels"),
+		"Number of bonded channels by direction and modulation class",
+		[]string{"host", "direction", "modulation_class"}, nil,
+	)
+	downstreamModulationVarietyMetric = b.newDesc(
+		prometheus.BuildFQName(namespace, DOWNSTREAM, "modulation_variety"),
+		"Number of distinct modulation types across downstream channels",
+		[]string{"host"}, nil,
+	)
+}
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- upMetric
+	ch <- scrapeErrorMetric
+	ch <- scrapeDurationMetric
+	ch <- connectDurationMetric
+	ch <- transferDurationMetric
+	ch <- requestsMetric
+	ch <- parseDurationMetric
+	ch <- servedFromCacheMetric
+	ch <- scrapesSkippedMetric
+	ch <- cacheAgeMetric
+	ch <- endpointInfoMetric
+	ch <- dataStaleMetric
+	ch <- panicsMetric
+	ch <- reloginsMetric
+	ch <- duplicateChannelsMetric
+	ch <- emitErrorsMetric
+	ch <- maintenanceMetric
+	ch <- activeModemMetric
 	ch <- connectedMetric
+	ch <- connectivityStatesMetric
+	ch <- eventLogMetric
+	ch <- eventLogLatestMetric
+	ch <- configFileOKMetric
+	ch <- memoryFreeMetric
+	ch <- cpuLoadMetric
+	ch <- spectrumAnalysisMetric
+	ch <- spectrumPowerMetric
+	ch <- startupStepMetric
+	ch <- lockThresholdPowerMetric
+	ch <- lockThresholdSNRMetric
+	ch <- maxDownstreamChannelsMetric
+	ch <- maxUpstreamChannelsMetric
+	ch <- downstreamChannelCountMetric
+	ch <- upstreamChannelCountMetric
+	ch <- lockedChannelCountMetric
 	ch <- uptimeMetric
+	ch <- clockSkewMetric
+	ch <- partialMetric
+	ch <- clockInSyncMetric
 	ch <- infoMetric
-	ch <- channelLockMetric
-	ch <- channelPowerMetric
-	ch <- channelSNRMetric
-	ch <- channelCorrectedMetric
-	ch <- channelUncorrectableMetric
-	ch <- channelInfoMetric
+	ch <- modemMismatchMetric
+	ch <- channelLockMetric[e.ChannelKey]
+	ch <- channelPowerMetric[e.ChannelKey]
+	ch <- channelPowerDeviationMetric[e.ChannelKey]
+	ch <- channelSNRMetric[e.ChannelKey]
+	ch <- channelFrequencyMetric[e.ChannelKey]
+	ch <- channelWidthMetric[e.ChannelKey]
+	ch <- channelRawPowerMetric[e.ChannelKey]
+	ch <- channelCorrectedMetric[e.ChannelKey]
+	ch <- channelCorrectedIntervalMetric[e.ChannelKey]
+	ch <- channelUncorrectableMetric[e.ChannelKey]
+	ch <- ofdmProfileMetric[e.ChannelKey]
+	ch <- ofdmProfileDownshiftedMetric[e.ChannelKey]
+	ch <- channelInfoMetric[e.ChannelKey]
+	ch <- channelLockedSinceMetric[e.ChannelKey]
+	ch <- t3TimeoutsMetric
+	ch <- t4TimeoutsMetric
+	ch <- channelLockRatioMetric
+	ch <- uncorrectableAllChannelsMetric
+	ch <- downstreamModulationVarietyMetric
+	ch <- channelsMetric
+	ch <- docsisVersionMetric
+	ch <- firmwareFamilyMetric
+	ch <- tableIncompleteMetric
+	ch <- dataCompletenessMetric
+}
+
+// Reasons a collection doesn't scrape the modem
+const (
+	skipMaintenance = "maintenance" // Maintenance mode is enabled
+	skipCacheHit    = "cache_hit"   // Served from the background scrape
+)
+
+var skipReasons = []string{skipMaintenance, skipCacheHit}
+
+// skipScrape counts a collection that didn't scrape the modem for reason,
+// or none when reason is empty, and emits the counters.
+func (e *Exporter) skipScrape(ch chan<- prometheus.Metric, reason string) {
+	e.mu.Lock()
+	if e.skippedScrapes == nil {
+		e.skippedScrapes = make(map[string]float64)
+	}
+	if reason != "" {
+		e.skippedScrapes[reason]++
+	}
+	skipped := make(map[string]float64, len(skipReasons))
+	for _, reason := range skipReasons {
+		skipped[reason] = e.skippedScrapes[reason]
+	}
+	e.mu.Unlock()
+
+	for reason, count := range skipped {
+		e.emit(ch,
+			scrapesSkippedMetric, prometheus.CounterValue, count, e.Host, reason,
+		)
+	}
+}
+
+// tableIncomplete emits whether a status page table came back with fewer
+// than the expected channels, nothing when its title carries no count.
+func (e *Exporter) tableIncomplete(ch chan<- prometheus.Metric, table string, parsed int, expected float64) {
+	if math.IsNaN(expected) {
+		return
+	}
+	incomplete := 0.
+	if float64(parsed) < expected {
+		incomplete = 1.
+	}
+	e.emit(ch,
+		tableIncompleteMetric, prometheus.GaugeValue, incomplete, e.Host, table,
+	)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	modem, err := e.Scrape()
-	if err != nil {
-		ch <- prometheus.MustNewConstMetric(
-			upMetric, prometheus.GaugeValue, 0,
+	if e.Maintenance.Enabled() {
+		e.skipScrape(ch, skipMaintenance)
+		e.emit(ch,
+			maintenanceMetric, prometheus.GaugeValue, 1, e.Host,
+		)
+		e.emit(ch,
+			upMetric, prometheus.GaugeValue, 0, e.Host,
 		)
+		return
+	}
+	e.emit(ch,
+		maintenanceMetric, prometheus.GaugeValue, 0, e.Host,
+	)
+
+	result, fromCache := e.modem()
+	if fromCache {
+		e.skipScrape(ch, skipCacheHit)
+	} else {
+		e.skipScrape(ch, "")
+	}
+
+	servedFromCache := 0.
+	if fromCache {
+		servedFromCache = 1.
+	}
+	e.emit(ch,
+		servedFromCacheMetric, prometheus.GaugeValue, servedFromCache, e.Host,
+	)
+	if fromCache {
+		e.emit(ch,
+			cacheAgeMetric, prometheus.GaugeValue, time.Since(result.time).Seconds(), e.Host,
+		)
+	}
+
+	scheme, address, port := e.Endpoint()
+	e.emit(ch,
+		endpointInfoMetric, prometheus.GaugeValue, 1, e.Host, scheme, address, port,
+	)
+
+	e.mu.Lock()
+	panics, relogins := e.panics, e.relogins
+	duplicates := map[string]float64{
+		DOWNSTREAM: e.duplicateChannels[DOWNSTREAM],
+		UPSTREAM:   e.duplicateChannels[UPSTREAM],
+	}
+	connectivityStates := make(map[string]float64, len(e.connectivityStates))
+	for state, count := range e.connectivityStates {
+		connectivityStates[state] = count
+	}
+	eventCounts := make(map[string]float64, len(e.eventCounts))
+	for priority, count := range e.eventCounts {
+		eventCounts[priority] = count
+	}
+	e.mu.Unlock()
+	e.emit(ch,
+		panicsMetric, prometheus.CounterValue, panics, e.Host,
+	)
+	e.emit(ch,
+		reloginsMetric, prometheus.CounterValue, relogins, e.Host,
+	)
+	for direction, count := range duplicates {
+		e.emit(ch,
+			duplicateChannelsMetric, prometheus.CounterValue, count, e.Host, direction,
+		)
+	}
+	for state, count := range connectivityStates {
+		e.emit(ch,
+			connectivityStatesMetric, prometheus.CounterValue, count, e.Host, state,
+		)
+	}
+	for priority, count := range eventCounts {
+		e.emit(ch,
+			eventLogMetric, prometheus.CounterValue, count, e.Host, priority,
+		)
+	}
+
+	if !fromCache || !e.ObservationTimestamps {
+		e.collect(ch, result)
+	} else {
+		// Stamp cached metrics with when the modem was read rather than when
+		// Prometheus scraped us, so rates stay accurate across a stale cache.
+		stamped := make(chan prometheus.Metric)
+		go func() {
+			e.collect(stamped, result)
+			close(stamped)
+		}()
+		for metric := range stamped {
+			ch <- prometheus.NewMetricWithTimestamp(result.time, metric)
+		}
+	}
+
+	// Last, so that errors from this collection are already counted
+	e.mu.Lock()
+	emitErrors := e.emitErrors
+	e.mu.Unlock()
+	e.emit(ch,
+		emitErrorsMetric, prometheus.CounterValue, emitErrors, e.Host,
+	)
+}
+
+// emit sends a metric, logging and counting it instead if it can't be built
+// (e.g. a label value that isn't valid UTF-8) so that one bad value doesn't
+// abort the rest of the collection.
+func (e *Exporter) emit(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	metric, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	if err != nil {
+		log.Errorf("Leaving out metric %s: %v", desc, err)
+		e.mu.Lock()
+		e.emitErrors++
+		e.mu.Unlock()
+		return
+	}
+	ch <- metric
+}
+
+// emitHz emits a channel's frequency or width, as shown by the modem, in Hz.
+// It is left out when the modem shows something that doesn't parse rather
+// than failing the scrape.
+func (e *Exporter) emitHz(ch chan<- prometheus.Metric, desc channelDesc, what string, channelKey string, direction string, channelID string, value string) {
+	hz, err := sb8200.ParseFrequency(value)
+	if err != nil {
+		log.Debugf("Leaving out the %s of %s channel %s of %s: %v", what, direction, channelID, e.Host, err)
+		return
+	}
+	e.emit(ch,
+		desc[e.ChannelKey], prometheus.GaugeValue, hz,
+		e.Host, channelKey, direction,
+	)
+}
+
+func (e *Exporter) collect(ch chan<- prometheus.Metric, result scrapeResult) {
+	// Whatever was sent before the panic is still served
+	defer func() {
+		if r := recover(); r != nil {
+			e.recoverPanic("collecting "+e.Host, r, debug.Stack())
+		}
+	}()
+
+	modem, err := result.modem, result.err
+	warmup, scrapedAt := result.warmup, result.time
+
+	// Request Timing Metrics, emitted even when the scrape failed
+	e.emit(ch,
+		scrapeDurationMetric, prometheus.GaugeValue, result.duration.Seconds(), e.Host,
+	)
+	e.emit(ch,
+		connectDurationMetric, prometheus.GaugeValue, modem.RequestTimings.Connect.Seconds(), e.Host,
+	)
+	e.emit(ch,
+		transferDurationMetric, prometheus.GaugeValue, modem.RequestTimings.Transfer.Seconds(), e.Host,
+	)
+	e.emit(ch,
+		requestsMetric, prometheus.GaugeValue, float64(modem.RequestTimings.Requests), e.Host,
+	)
+
+	up, stale := 1., 0.
+	if err != nil {
 		log.Error(err)
+		up = 0
+		if result.held != nil {
+			// Hold the last good values over a brief failure rather than
+			// leaving gaps, but don't count its error deltas twice.
+			stale = 1
+			modem = result.held.modem
+			warmup, scrapedAt = result.held.warmup, result.held.time
+			result.correctedInterval = nil
+		}
+	}
+	e.emit(ch,
+		upMetric, prometheus.GaugeValue, up, e.Host,
+	)
+	failedStage := ""
+	if err != nil {
+		failedStage = sb8200.ScrapeStage(err)
+	}
+	for _, stage := range sb8200.ScrapeStages {
+		failed := 0.
+		if stage == failedStage {
+			failed = 1
+		}
+		e.emit(ch,
+			scrapeErrorMetric, prometheus.GaugeValue, failed, e.Host, stage,
+		)
+	}
+	e.emit(ch,
+		dataStaleMetric, prometheus.GaugeValue, stale, e.Host,
+	)
+	if err != nil && result.held == nil {
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(
-		upMetric, prometheus.GaugeValue, 1,
+
+	// Active Modem Metric, under the primary's host so it can't clash with
+	// the series of a backup that's also scraped as a target of its own
+	if e.Backup != nil {
+		primaryActive, backupActive := 1., 0.
+		if modem.Host == e.Backup.Host {
+			primaryActive, backupActive = 0., 1.
+		}
+		e.emit(ch,
+			activeModemMetric, prometheus.GaugeValue, primaryActive, e.Host, "primary",
+		)
+		e.emit(ch,
+			activeModemMetric, prometheus.GaugeValue, backupActive, e.Host, "backup",
+		)
+	}
+
+	// Firmware Family Metric
+	e.emit(ch,
+		firmwareFamilyMetric, prometheus.GaugeValue, 1, e.Host, modem.FirmwareFamily,
+	)
+
+	// Table Incomplete Metrics
+	e.tableIncomplete(ch, DOWNSTREAM, len(modem.DownstreamBondedChannels), modem.ExpectedDownstream)
+	e.tableIncomplete(ch, UPSTREAM, len(modem.UpstreamBondedChannels), modem.ExpectedUpstream)
+
+	// Data Completeness Metric
+	e.emit(ch,
+		dataCompletenessMetric, prometheus.GaugeValue, modem.Completeness, e.Host,
+	)
+
+	// Parse Duration Metric
+	e.emit(ch,
+		parseDurationMetric, prometheus.GaugeValue, modem.ParseDuration.Seconds(), e.Host,
 	)
 
 	// Connected Metric
-	ch <- prometheus.MustNewConstMetric(
-		connectedMetric, prometheus.GaugeValue, modem.ConnectivityState,
+	e.emit(ch,
+		connectedMetric, prometheus.GaugeValue, modem.ConnectivityState, e.Host,
+	)
+
+	// Config File Metric
+	if modem.ConfigFileStatus != "" {
+		configFileOK := 0.
+		if modem.ConfigFileStatus == "OK" {
+			configFileOK = 1.
+		}
+		e.emit(ch,
+			configFileOKMetric, prometheus.GaugeValue, configFileOK, e.Host,
+		)
+	}
+
+	// Diagnostics Metrics
+	if modem.Diagnostics != nil {
+		if !math.IsNaN(modem.Diagnostics.MemoryFreeBytes) {
+			e.emit(ch,
+				memoryFreeMetric, prometheus.GaugeValue, modem.Diagnostics.MemoryFreeBytes, e.Host,
+			)
+		}
+		if !math.IsNaN(modem.Diagnostics.CPULoad) {
+			e.emit(ch,
+				cpuLoadMetric, prometheus.GaugeValue, modem.Diagnostics.CPULoad, e.Host,
+			)
+		}
+		if !math.IsNaN(modem.Diagnostics.SpectrumAnalysis) {
+			e.emit(ch,
+				spectrumAnalysisMetric, prometheus.GaugeValue, modem.Diagnostics.SpectrumAnalysis, e.Host,
+			)
+		}
+		for _, bin := range modem.Diagnostics.Spectrum {
+			e.emit(ch,
+				spectrumPowerMetric, prometheus.GaugeValue, e.round(bin.Power),
+				e.Host, strconv.FormatFloat(bin.Frequency, 'f', 0, 64),
+			)
+		}
+	}
+
+	// Event Log Metrics
+	if modem.EventLog != nil {
+		var latest time.Time
+		for _, event := range modem.EventLog.Events {
+			if event.Time.After(latest) {
+				latest = event.Time
+			}
+		}
+		if !latest.IsZero() {
+			e.emit(ch,
+				eventLogLatestMetric, prometheus.GaugeValue, float64(latest.Unix()), e.Host,
+			)
+		}
+	}
+
+	// Startup Procedure Metrics
+	for _, step := range modem.StartupSteps {
+		e.emit(ch,
+			startupStepMetric, prometheus.GaugeValue, step.OK,
+			e.Host, step.Procedure,
+		)
+	}
+
+	// Lock Threshold Metrics
+	for _, threshold := range modem.LockThresholds {
+		desc := lockThresholdSNRMetric
+		if threshold.Unit == "dBmV" {
+			desc = lockThresholdPowerMetric
+		}
+		e.emit(ch,
+			desc, prometheus.GaugeValue, threshold.Value,
+			e.Host, threshold.Name,
+		)
+	}
+
+	// Channel Capacity Metrics
+	if !math.IsNaN(modem.MaxDownstreamChannels) {
+		e.emit(ch,
+			maxDownstreamChannelsMetric, prometheus.GaugeValue, modem.MaxDownstreamChannels, e.Host,
+		)
+	}
+	if !math.IsNaN(modem.MaxUpstreamChannels) {
+		e.emit(ch,
+			maxUpstreamChannelsMetric, prometheus.GaugeValue, modem.MaxUpstreamChannels, e.Host,
+		)
+	}
+
+	// Channel Count Metrics, a single series to alert on lost channels
+	var downstreamLocked, upstreamLocked float64
+	for _, channel := range modem.DownstreamBondedChannels {
+		downstreamLocked += channel.LockStatus
+	}
+	for _, channel := range modem.UpstreamBondedChannels {
+		upstreamLocked += channel.LockStatus
+	}
+	e.emit(ch,
+		downstreamChannelCountMetric, prometheus.GaugeValue, float64(len(modem.DownstreamBondedChannels)), e.Host,
+	)
+	e.emit(ch,
+		upstreamChannelCountMetric, prometheus.GaugeValue, float64(len(modem.UpstreamBondedChannels)), e.Host,
 	)
+	e.emit(ch,
+		lockedChannelCountMetric, prometheus.GaugeValue, downstreamLocked, e.Host, DOWNSTREAM,
+	)
+	e.emit(ch,
+		lockedChannelCountMetric, prometheus.GaugeValue, upstreamLocked, e.Host, UPSTREAM,
+	)
+
+	// Uptime Metric, omitted when the product information page timed out
+	if !math.IsNaN(modem.Uptime) {
+		e.emit(ch,
+			uptimeMetric, prometheus.GaugeValue, modem.Uptime, e.Host,
+		)
+	}
 
-	// Uptime Metric
-	ch <- prometheus.MustNewConstMetric(
-		uptimeMetric, prometheus.GaugeValue, modem.Uptime,
+	// Partial Scrape Metric
+	partial := 0.
+	if modem.Partial {
+		partial = 1.
+	}
+	e.emit(ch,
+		partialMetric, prometheus.GaugeValue, partial, e.Host,
 	)
 
+	// Clock Metrics, omitted when the modem doesn't show its clock
+	if !modem.SystemTime.IsZero() {
+		skew := modem.SystemTime.Sub(scrapedAt)
+		inSync := 0.
+		if skew <= e.ClockTolerance && skew >= -e.ClockTolerance {
+			inSync = 1.
+		}
+		e.emit(ch,
+			clockSkewMetric, prometheus.GaugeValue, skew.Seconds(), e.Host,
+		)
+		e.emit(ch,
+			clockInSyncMetric, prometheus.GaugeValue, inSync, e.Host,
+		)
+	}
+
 	// Modem Meta Metric
-	ch <- prometheus.MustNewConstMetric(
-		infoMetric, prometheus.GaugeValue, 1,
-		e.Host, modem.HardwareVersion, modem.SoftwareVersion,
-		modem.MACAddress, modem.SerialNumber,
-	)
+	if e.LogStaticInfo {
+		e.logInfo(modem)
+	} else {
+		e.emit(ch,
+			infoMetric, prometheus.GaugeValue, 1,
+			e.Host, modem.HardwareVersion, modem.SoftwareVersion,
+			modem.MACAddress, modem.SerialNumber,
+		)
+	}
+
+	// Modem Mismatch Metric, not checked against a backup modem
+	if (e.ExpectedSerial != "" || e.ExpectedMAC != "") && modem.Host == e.Host {
+		mismatch := 0.
+		if e.mismatch(modem) {
+			mismatch = 1.
+		}
+		e.emit(ch,
+			modemMismatchMetric, prometheus.GaugeValue, mismatch, e.Host,
+		)
+	}
+
+	channelCounts := map[string]map[string]float64{
+		DOWNSTREAM: {SCQAM: 0, OFDM: 0},
+		UPSTREAM:   {SCQAM: 0, OFDMA: 0},
+	}
 
 	// Downstream Channels
-	for _, channel := range modem.DownstreamBondedChannels {
+	downstreamPowers := make([]float64, len(modem.DownstreamBondedChannels))
+	for i, channel := range modem.DownstreamBondedChannels {
+		downstreamPowers[i] = channel.Power
+	}
+	downstreamMedianPower := median(downstreamPowers)
+	modulations := make(map[string]struct{})
+	uncorrectable := 0.
+	locked := map[string]float64{DOWNSTREAM: 0, UPSTREAM: 0}
+	for i, channel := range modem.DownstreamBondedChannels {
+		channelKey := e.channelKey(i, channel.ChannelID)
+		modulations[channel.Modulation] = struct{}{}
+		uncorrectable += channel.UncorrectableErrors
+		locked[DOWNSTREAM] += channel.LockStatus
+		channelCounts[DOWNSTREAM][modulationClass(DOWNSTREAM, channel.Modulation)]++
+
 		// Lock Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelLockMetric, prometheus.GaugeValue, channel.LockStatus,
-			channel.ChannelID, DOWNSTREAM,
+		e.emit(ch,
+			channelLockMetric[e.ChannelKey], e.LockValueType, channel.LockStatus,
+			e.Host, channelKey, DOWNSTREAM,
 		)
+		if since, ok := result.lockedSince[lockKey(DOWNSTREAM, channel.ChannelID)]; ok {
+			e.emit(ch,
+				channelLockedSinceMetric[e.ChannelKey], prometheus.GaugeValue, since,
+				e.Host, channelKey, DOWNSTREAM,
+			)
+		}
 
 		// Power Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelPowerMetric, prometheus.GaugeValue, channel.Power,
-			channel.ChannelID, DOWNSTREAM,
+		e.emit(ch,
+			channelPowerMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.Power),
+			e.Host, channelKey, DOWNSTREAM,
+		)
+		e.emit(ch,
+			channelPowerDeviationMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.Power-downstreamMedianPower),
+			e.Host, channelKey, DOWNSTREAM,
 		)
+		if e.RawValues {
+			e.emit(ch,
+				channelRawPowerMetric[e.ChannelKey], prometheus.GaugeValue, channel.Power,
+				e.Host, channelKey, DOWNSTREAM, channel.RawPower,
+			)
+		}
 
 		// SNR Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelSNRMetric, prometheus.GaugeValue, channel.SNR,
-			channel.ChannelID, DOWNSTREAM,
+		e.emit(ch,
+			channelSNRMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.SNR),
+			e.Host, channelKey, DOWNSTREAM,
 		)
 
+		// Frequency Metric
+		e.emitHz(ch, channelFrequencyMetric, "frequency", channelKey, DOWNSTREAM, channel.ChannelID, channel.Frequency)
+
 		// Corrected Errors Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelCorrectedMetric, prometheus.CounterValue, channel.CorrectedErrors,
-			channel.ChannelID, DOWNSTREAM,
-		)
+		if !warmup {
+			e.emit(ch,
+				channelCorrectedMetric[e.ChannelKey], prometheus.CounterValue, channel.CorrectedErrors,
+				e.Host, channelKey, DOWNSTREAM,
+			)
+		}
+
+		// Corrected Errors Interval Metric
+		if interval, ok := result.correctedInterval[channel.ChannelID]; ok {
+			e.emit(ch,
+				channelCorrectedIntervalMetric[e.ChannelKey], prometheus.GaugeValue, interval,
+				e.Host, channelKey, DOWNSTREAM,
+			)
+		}
 
 		// Uncorrectable Errors Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelUncorrectableMetric, prometheus.CounterValue, channel.UncorrectableErrors,
-			channel.ChannelID, DOWNSTREAM,
-		)
+		if !warmup {
+			e.emit(ch,
+				channelUncorrectableMetric[e.ChannelKey], prometheus.CounterValue, channel.UncorrectableErrors,
+				e.Host, channelKey, DOWNSTREAM,
+			)
+		}
+
+		// OFDM Profile Metrics
+		if !math.IsNaN(channel.ProfileID) {
+			e.emit(ch,
+				ofdmProfileMetric[e.ChannelKey], prometheus.GaugeValue, channel.ProfileID,
+				e.Host, channelKey, DOWNSTREAM, channel.Profile,
+			)
+			if highest, ok := result.highestProfile[channel.ChannelID]; ok {
+				downshifted := 0.
+				if channel.ProfileID < highest {
+					downshifted = 1.
+				}
+				e.emit(ch,
+					ofdmProfileDownshiftedMetric[e.ChannelKey], prometheus.GaugeValue, downshifted,
+					e.Host, channelKey, DOWNSTREAM, channel.Profile,
+				)
+			}
+		}
 
 		// Meta Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelInfoMetric, prometheus.GaugeValue, 1,
-			channel.ChannelID, channel.Modulation, channel.Frequency,
-			"", DOWNSTREAM,
+		e.emit(ch,
+			channelInfoMetric[e.ChannelKey], prometheus.GaugeValue, 1,
+			e.Host, channelKey, channel.Modulation, channel.Frequency,
+			frequencyMHz(channel.Frequency), e.band(channel.Frequency), "", DOWNSTREAM,
 		)
 	}
 
+	// Uncorrectable Errors Across All Channels Metric
+	if !warmup {
+		e.emit(ch,
+			uncorrectableAllChannelsMetric, prometheus.CounterValue, uncorrectable,
+			e.Host,
+		)
+	}
+
+	// Modulation Variety Metric
+	e.emit(ch,
+		downstreamModulationVarietyMetric, prometheus.GaugeValue, float64(len(modulations)),
+		e.Host,
+	)
+
 	// Upstream Channels
-	for _, channel := range modem.UpstreamBondedChannels {
+	upstreamPowers := make([]float64, len(modem.UpstreamBondedChannels))
+	for i, channel := range modem.UpstreamBondedChannels {
+		upstreamPowers[i] = channel.Power
+	}
+	upstreamMedianPower := median(upstreamPowers)
+	for i, channel := range modem.UpstreamBondedChannels {
+		channelKey := e.channelKey(i, channel.ChannelID)
+		locked[UPSTREAM] += channel.LockStatus
+		channelCounts[UPSTREAM][modulationClass(UPSTREAM, channel.USChannelType)]++
+
 		// Lock Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelLockMetric, prometheus.GaugeValue, channel.LockStatus,
-			channel.ChannelID, UPSTREAM,
+		e.emit(ch,
+			channelLockMetric[e.ChannelKey], e.LockValueType, channel.LockStatus,
+			e.Host, channelKey, UPSTREAM,
 		)
+		if since, ok := result.lockedSince[lockKey(UPSTREAM, channel.ChannelID)]; ok {
+			e.emit(ch,
+				channelLockedSinceMetric[e.ChannelKey], prometheus.GaugeValue, since,
+				e.Host, channelKey, UPSTREAM,
+			)
+		}
 
 		// Power Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelPowerMetric, prometheus.GaugeValue, channel.Power,
-			channel.ChannelID, UPSTREAM,
+		e.emit(ch,
+			channelPowerMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.Power),
+			e.Host, channelKey, UPSTREAM,
+		)
+		e.emit(ch,
+			channelPowerDeviationMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.Power-upstreamMedianPower),
+			e.Host, channelKey, UPSTREAM,
 		)
 
+		// SNR Metric, only reported by newer firmware
+		if !math.IsNaN(channel.SNR) {
+			e.emit(ch,
+				channelSNRMetric[e.ChannelKey], prometheus.GaugeValue, e.round(channel.SNR),
+				e.Host, channelKey, UPSTREAM,
+			)
+		}
+
+		// Frequency and Width Metrics
+		e.emitHz(ch, channelFrequencyMetric, "frequency", channelKey, UPSTREAM, channel.ChannelID, channel.Frequency)
+		e.emitHz(ch, channelWidthMetric, "width", channelKey, UPSTREAM, channel.ChannelID, channel.Width)
+		if e.RawValues {
+			e.emit(ch,
+				channelRawPowerMetric[e.ChannelKey], prometheus.GaugeValue, channel.Power,
+				e.Host, channelKey, UPSTREAM, channel.RawPower,
+			)
+		}
+
 		// Meta Metric
-		ch <- prometheus.MustNewConstMetric(
-			channelInfoMetric, prometheus.GaugeValue, 1,
-			channel.ChannelID, channel.USChannelType, channel.Frequency,
-			channel.Width, UPSTREAM,
+		e.emit(ch,
+			channelInfoMetric[e.ChannelKey], prometheus.GaugeValue, 1,
+			e.Host, channelKey, channel.USChannelType, channel.Frequency,
+			frequencyMHz(channel.Frequency), e.band(channel.Frequency), channel.Width, UPSTREAM,
+		)
+	}
+
+	// T3/T4 Timeout Metrics, counted from the event log on firmware whose
+	// upstream table has no timeout columns, and omitted when neither is
+	// read or while warming up
+	t3Timeouts, t4Timeouts := math.NaN(), math.NaN()
+	for _, channel := range modem.UpstreamBondedChannels {
+		t3Timeouts = addReported(t3Timeouts, channel.T3Timeouts)
+		t4Timeouts = addReported(t4Timeouts, channel.T4Timeouts)
+	}
+	if math.IsNaN(t3Timeouts) && math.IsNaN(t4Timeouts) {
+		e.mu.Lock()
+		if e.timeoutEvents != nil {
+			t3Timeouts, t4Timeouts = e.timeoutEvents[3], e.timeoutEvents[4]
+		}
+		e.mu.Unlock()
+	}
+	if !math.IsNaN(t3Timeouts) && !warmup {
+		e.emit(ch,
+			t3TimeoutsMetric, prometheus.CounterValue, t3Timeouts, e.Host,
+		)
+	}
+	if !math.IsNaN(t4Timeouts) && !warmup {
+		e.emit(ch,
+			t4TimeoutsMetric, prometheus.CounterValue, t4Timeouts, e.Host,
+		)
+	}
+
+	// Lock Ratio Metric, omitted for a direction without channels
+	for direction, total := range map[string]int{
+		DOWNSTREAM: len(modem.DownstreamBondedChannels),
+		UPSTREAM:   len(modem.UpstreamBondedChannels),
+	} {
+		if total == 0 {
+			continue
+		}
+		e.emit(ch,
+			channelLockRatioMetric, prometheus.GaugeValue, locked[direction]/float64(total),
+			e.Host, direction,
 		)
 	}
+
+	// DOCSIS Version Metric
+	docsisVersion, docsisVersionSource := modem.DOCSISVersion, "reported"
+	if docsisVersion == "" {
+		docsisVersion, docsisVersionSource = "3.0", "inferred"
+		if channelCounts[DOWNSTREAM][OFDM] > 0 || channelCounts[UPSTREAM][OFDMA] > 0 {
+			docsisVersion = "3.1"
+		}
+	}
+	e.emit(ch,
+		docsisVersionMetric, prometheus.GaugeValue, 1,
+		e.Host, docsisVersion, docsisVersionSource,
+	)
+
+	// Channel Composition Metric
+	for direction, classes := range channelCounts {
+		for class, count := range classes {
+			e.emit(ch,
+				channelsMetric, prometheus.GaugeValue, count,
+				e.Host, direction, class,
+			)
+		}
+	}
+}
+
+// Exporters lets several modems share a single registry. The descriptors are
+// the same for every modem and only differ by the host label.
+type Exporters []*Exporter
+
+func (es Exporters) Describe(ch chan<- *prometheus.Desc) {
+	if len(es) > 0 {
+		es[0].Describe(ch)
+	}
+}
+
+func (es Exporters) Collect(ch chan<- prometheus.Metric) {
+	for _, e := range es {
+		e.Collect(ch)
+	}
 }
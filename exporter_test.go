@@ -0,0 +1,169 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ocelotsloth/sb8200-exporter/pkg/sb8200"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestModem serves the modem pages in pkg/sb8200/testdata behind the
+// modem's login.
+func newTestModem(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logout.html" {
+			return
+		}
+		if strings.HasPrefix(r.URL.RawQuery, "login_") {
+			http.SetCookie(w, &http.Cookie{Name: "sessionId", Value: "testsession"})
+			w.Write([]byte("testtoken"))
+			return
+		}
+		if cookie, err := r.Cookie("sessionId"); err != nil || cookie.Value != "testsession" {
+			w.Write([]byte("<html><head><title>Login</title></head></html>"))
+			return
+		}
+		page, err := os.ReadFile(filepath.Join("pkg", "sb8200", "testdata", filepath.Base(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// gatherGauge collects from e and returns the values of the named gauge.
+func gatherGauge(t *testing.T, e prometheus.Collector, name string) (values []float64) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			for _, metric := range family.Metric {
+				values = append(values, metric.GetGauge().GetValue())
+			}
+		}
+	}
+	return
+}
+
+// gatherCounter collects from e and returns the values of the named counter.
+func gatherCounter(t *testing.T, e prometheus.Collector, name string) (values []float64) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			for _, metric := range family.Metric {
+				values = append(values, metric.GetCounter().GetValue())
+			}
+		}
+	}
+	return
+}
+
+// gatherByLabel collects from e and returns the values of the named gauge by
+// the value of label, failing if any series' host isn't e's.
+func gatherByLabel(t *testing.T, e *Exporter, name string, label string) map[string]float64 {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(e)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.Metric {
+			labels := map[string]string{}
+			for _, pair := range metric.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["host"] != e.Host {
+				t.Errorf("%s has host %q, want only %q", name, labels["host"], e.Host)
+			}
+			values[labels[label]] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestScrapeDurationMetric(t *testing.T) {
+	modem := newTestModem(t)
+	e := NewExporter(strings.TrimPrefix(modem.URL, "https://"), "admin", "password")
+	durations := gatherGauge(t, e, "sb8200_scrape_duration_seconds")
+	if len(durations) != 1 {
+		t.Fatalf("got %d sb8200_scrape_duration_seconds series, want 1", len(durations))
+	}
+	if duration := durations[0]; duration <= 0 {
+		t.Errorf("scrape took %v seconds, want a positive duration", duration)
+	}
+
+	// Also emitted when the scrape fails
+	unreachable := NewExporter("127.0.0.1:1", "admin", "password")
+	if durations := gatherGauge(t, unreachable, "sb8200_scrape_duration_seconds"); len(durations) != 1 {
+		t.Errorf("got %d sb8200_scrape_duration_seconds series after a failed scrape, want 1", len(durations))
+	}
+}
+
+func TestActiveModemMetric(t *testing.T) {
+	modem := newTestModem(t)
+	e := NewExporter("127.0.0.1:1", "admin", "password")
+	e.Backup = sb8200.NewExporter(strings.TrimPrefix(modem.URL, "https://"), "admin", "password")
+	active := gatherByLabel(t, e, "sb8200_active_modem", "active")
+	if len(active) != 2 || active["primary"] != 0 || active["backup"] != 1 {
+		t.Errorf("sb8200_active_modem by active label %v, want primary 0 and backup 1", active)
+	}
+}
+
+func TestScrapeErrorStageAfterFailover(t *testing.T) {
+	e := NewExporter("127.0.0.1:1", "admin", "password")
+	e.Backup = sb8200.NewExporter("127.0.0.1:1", "admin", "password")
+	stages := gatherByLabel(t, e, "sb8200_scrape_error", "error")
+	if stages[sb8200.StageLogin] != 1 {
+		t.Errorf("sb8200_scrape_error by stage %v, want the primary's login failure", stages)
+	}
+}
+
+func TestTimeoutsFromEventLog(t *testing.T) {
+	modem := newTestModem(t)
+	e := NewExporter(strings.TrimPrefix(modem.URL, "https://"), "admin", "password")
+	e.EventLogPath = "cmeventlog.html"
+	for name, want := range map[string]float64{
+		"sb8200_t3_timeouts_total": 2,
+		"sb8200_t4_timeouts_total": 1,
+	} {
+		if values := gatherCounter(t, e, name); len(values) != 1 || values[0] != want {
+			t.Errorf("%s = %v, want %v counted from the event log", name, values, want)
+		}
+	}
+}
@@ -7,6 +7,9 @@ require (
 	github.com/gorilla/handlers v1.5.1
 	github.com/prometheus/client_golang v1.11.0
 	github.com/prometheus/common v0.26.0
+	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8
+	golang.org/x/sync v0.1.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -27,8 +30,8 @@ require (
 	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/procfs v0.6.0 // indirect
 	github.com/sirupsen/logrus v1.6.0 // indirect
-	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8 // indirect
 	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
+	golang.org/x/text v0.3.6 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6 // indirect
 )
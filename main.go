@@ -15,32 +15,585 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/ocelotsloth/sb8200-exporter/pkg/sb8200"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	listenAddress = flag.String("web.listen-address", ":9143",
-		"Address to listen on for telemetry")
+		"Address to listen on for telemetry, defaults to $WEB_LISTEN_ADDRESS when set")
 	metricsPath = flag.String("web.telemetry-path", "/metrics",
-		"Path under which to expose metrics")
+		"Path under which to expose metrics, defaults to $WEB_TELEMETRY_PATH when set")
+	lockType = flag.String("metrics.lock-type", "gauge",
+		"Value type of the channel lock metric (gauge or untyped)")
+	channelKey = flag.String("metrics.channel-key", ChannelKeyID,
+		"Label identifying per-channel series, id (channel_id) or index (channel_index, the row in the modem's table)")
+	bands = flag.String("metrics.bands", "low:300,mid:700,high",
+		"Frequency bands for the band label of sb8200_channel_info, as name:upper bound in MHz pairs from lowest to highest, the last without a bound. Empty to disable")
+	hostFile = flag.String("modem.host-file", "",
+		"File to read the modem's address from, takes precedence over ARRIS_CM_HOST")
+	passwordFile = flag.String("modem.password-file", "",
+		"File to read the modem's password from, takes precedence over ARRIS_CM_PASSWORD")
+	verboseHelp = flag.Bool("metrics.verbose-help", false,
+		"Use longer metric help strings that spell out units and value ranges")
+	roundPrecision = flag.Int("metrics.round-precision", -1,
+		"Round power and SNR readings to this many decimals, -1 for no rounding")
+	spectrumBins = flag.Int("modem.spectrum-bins", 0,
+		"Expose up to this many spectrum analysis samples from -modem.diagnostics-page as sb8200_spectrum_power_dbmv, 0 to skip them")
+	configFile = flag.String("config.file", "",
+		"Path to a YAML file of modems to poll in the background")
+	diagnosticsPage = flag.String("modem.diagnostics-page", "",
+		"Path of a hidden diagnostics page reporting modem memory and CPU load, disabled when empty")
+	eventLogPage = flag.String("modem.event-log-page", "cmeventlog.html",
+		"Path of the modem's event log page counted by sb8200_event_log_total, disabled when empty")
+	eventLogMaxRows = flag.Int("eventlog.max-rows", 500,
+		"Keep at most this many of the newest event log rows, 0 for no limit. The page is tokenized rather than parsed into a document, so this bounds the memory a long log's events take")
+	logStaticInfo = flag.Bool("metrics.log-static-info", false,
+		"Log modem metadata (versions, MAC, serial) when it changes instead of exposing sb8200_info")
+	observationTimestamps = flag.Bool("metrics.observation-timestamps", false,
+		"Timestamp metrics served from the background cache with when the modem was scraped")
+	holdLastGood = flag.Duration("metrics.hold-last-good", 0,
+		"Keep serving the last successful scrape for this long when scrapes fail, flagged by sb8200_data_stale, 0 to disable")
+	scheme = flag.String("modem.scheme", "https",
+		"URL scheme of the modem's web interface, http for firmware that only serves plain HTTP")
+	h2c = flag.Bool("modem.h2c", false,
+		"Talk to the modem over cleartext HTTP/2 (h2c) instead of HTTPS, for proxies that only speak h2c")
+	requireUpstream = flag.Bool("scrape.require-upstream", false,
+		"Treat a status page without upstream channels as a failed scrape")
+	retryIncomplete = flag.Bool("scrape.retry-incomplete-tables", false,
+		"Fetch the status page again when a channel table has fewer rows than its title says, sb8200_table_incomplete reports it either way")
+	rawValues = flag.Bool("debug.raw-values", false,
+		"Expose sb8200_channel_raw_power_parsed labelled with the raw strings read from the modem")
+	maintenanceFlag = flag.Bool("maintenance", false,
+		"Start in maintenance mode, reporting up 0 without contacting the modem, toggle with POST /-/maintenance?enabled=false")
+	startupSplay = flag.Duration("scrape.startup-splay", 0,
+		"Wait a random time up to this long before the first background scrape, to stagger exporters started together")
+	debugStream = flag.Bool("debug.stream", false,
+		"Serve every scrape result as JSON lines on /debug/stream while the connection stays open")
+	warmupScrapes = flag.Int("scrape.warmup-scrapes", 0,
+		"Leave the modem's error counters out of the first this many successful scrapes, to avoid rate spikes right after startup")
+	retryBudget = flag.Int("scrape.retry-budget", 1,
+		"Retries a single scrape may spend across login and page fetches")
+	retryBudgetTime = flag.Duration("scrape.retry-budget-time", 0,
+		"Stop retrying once a scrape has been running this long, 0 for no limit")
+	powerHistogram = flag.Bool("metrics.power-histogram", false,
+		"Expose a histogram of downstream channel power observed on every scrape")
+	uncorrectableHistogram = flag.Bool("metrics.uncorrectable-histogram", false,
+		"Expose a histogram of the uncorrectable errors each downstream channel gained between consecutive scrapes")
+	structuredStatus = flag.String("modem.structured-status", "",
+		"Path of an XML/JSON status endpoint (e.g. cmconnectionstatus.xml) to prefer over the HTML page, disabled when empty")
+	loginURL = flag.String("modem.login-url", "",
+		"URL of the page to log in on (e.g. https://192.168.100.1/cmconnectionstatus.html), defaults to the status page")
+	username = flag.String("modem.username", "admin",
+		"User to log in to the web interface of the modem given by ARRIS_CM_HOST as, defaults to $ARRIS_CM_USER when set")
+	sessionCookie = flag.String("modem.session-cookie", "",
+		"sessionId cookie obtained elsewhere to fetch pages with instead of logging in, until the modem rejects it. Only applies to the modem given by ARRIS_CM_HOST")
+	sessionTTL = flag.Duration("modem.session-ttl", 5*time.Minute,
+		"Reuse the session of a login for scrapes within this long of it instead of logging in every time, logging in again early if the modem ends it. 0 to log in on every scrape")
+	caCert = flag.String("modem.ca-cert", "",
+		"PEM file of the certificate (or its CA) to verify the modem's TLS certificate against. The hostname isn't checked, as the modem's certificate doesn't name its address. Empty to accept any certificate, as the modem's is self-signed")
+	clockTolerance = flag.Duration("modem.clock-tolerance", 30*time.Second,
+		"Largest difference between the modem and exporter clocks sb8200_clock_in_sync reports as in sync")
+	expectedSerial = flag.String("modem.expected-serial", "",
+		"Serial number the modem should report, sb8200_modem_mismatch flags a different one, e.g. after the ISP swapped the modem")
+	expectedMAC = flag.String("modem.expected-mac", "",
+		"MAC address the modem should report, sb8200_modem_mismatch flags a different one")
+	requestMethod = flag.String("modem.method", "GET",
+		"HTTP method used to fetch the modem's status pages")
+	logoutMethod = flag.String("modem.logout-method", "GET",
+		"HTTP method of the logout that clears any previous session before logging in, some firmware needs POST")
+	testConfig = flag.String("test-config", "",
+		"Validate this config file, print OK or the problems found and exit")
+	etag = flag.Bool("web.etag", false,
+		"Tag /metrics with an ETag of the current background scrapes, answering conditional requests with 304 Not Modified until the next poll. Only applies while polling")
+	recordFile = flag.String("record.file", "",
+		"Append the channels of every background scrape to this CSV file for analysis without Prometheus, disabled when empty. Only applies to modems polled from -config.file")
+	recordMaxBytes = flag.Int64("record.max-bytes", 64<<20,
+		"Size at which -record.file is moved to -record.file.1, replacing the previous one, 0 to let it grow")
+	scrapeTimeout = flag.Duration("scrape.timeout", 10*time.Second,
+		"Give up on a scrape of the modem, login included, after this long and report it as down, 0 for no limit")
+	requestTimeout = flag.Duration("modem.request-timeout", 0,
+		"Abandon a single request to the modem after this long, 0 for no limit. A product information page that times out is left out and reported by sb8200_scrape_partial")
+	concurrency = flag.Int("modem.concurrency", 2,
+		"Pages fetched at once after logging in, 1 to fetch them one after another if the modem trips over concurrent requests")
+
+	// Shared by every exporter, toggled by /-/maintenance
+	maintenance = &MaintenanceMode{}
+
+	// Resolved from -metrics.lock-type at startup
+	lockValueType prometheus.ValueType
+	// Parsed from -metrics.bands at startup
+	frequencyBands []FrequencyBand
+	// Shared by every exporter, nil unless -record.file is set
+	recorder *Recorder
+	// Loaded from -modem.ca-cert at startup, nil to skip verification
+	modemCAs *x509.CertPool
+
+	// Parsed from the repeatable -web.allow-cidr flag
+	allowedCIDRs cidrList
+	// Parsed from the repeatable -modem.header flag
+	requestHeaders = headerList{}
+)
+
+func init() {
+	flag.Var(&allowedCIDRs, "web.allow-cidr",
+		"Only serve metrics to clients in this network (e.g. 192.168.1.0/24), may be repeated, everybody is allowed when unset. /-/maintenance only allows the exporter's own host when unset")
+	flag.Var(requestHeaders, "modem.header",
+		"Extra header sent with every request to the modem as key=value (e.g. Connection=close), may be repeated")
+}
+
+var (
+	headerNameRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	methodRegexp     = regexp.MustCompile(`^[A-Z]+$`)
 )
 
+// headerList is a repeatable key=value flag of extra request headers.
+type headerList http.Header
+
+func (l headerList) String() string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	headers := []string{}
+	for _, key := range keys {
+		for _, value := range l[key] {
+			headers = append(headers, key+"="+value)
+		}
+	}
+	return strings.Join(headers, ",")
+}
+
+func (l headerList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("header %q must look like key=value", value)
+	}
+	key, val := strings.TrimSpace(parts[0]), parts[1]
+	if !headerNameRegexp.MatchString(key) {
+		return fmt.Errorf("invalid header name %q", key)
+	}
+	if strings.ContainsAny(val, "\r\n") {
+		return fmt.Errorf("header %q value must not contain line breaks", key)
+	}
+	http.Header(l).Add(key, strings.TrimSpace(val))
+	return nil
+}
+
+// serveMaintenance reports whether maintenance mode is enabled, and switches
+// it on a POST with ?enabled=true or ?enabled=false.
+func serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		maintenance.Set(enabled)
+		log.Printf("Maintenance mode enabled=%t by %s", enabled, r.RemoteAddr)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintf(w, "enabled=%t\n", maintenance.Enabled())
+}
+
+// readFileValue reads a setting such as a mounted Kubernetes ConfigMap or
+// Secret key, ignoring surrounding whitespace.
+func readFileValue(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
+
+// flagEnvVars are the environment variables backing flags not given on the
+// command line.
+var flagEnvVars = map[string]string{
+	"web.listen-address": "WEB_LISTEN_ADDRESS",
+	"web.telemetry-path": "WEB_TELEMETRY_PATH",
+	"modem.username":     "ARRIS_CM_USER",
+}
+
+// setFlagsFromEnv sets the flags of flagEnvVars that weren't given on the
+// command line from their environment variables, if set.
+func setFlagsFromEnv() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, env := range flagEnvVars {
+		value := os.Getenv(env)
+		if given[name] || value == "" {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", env, err)
+		}
+	}
+	return nil
+}
+
+// configHash returns a stable hash of every command line flag's effective
+// value and of the config file, so exporters that were started with different
+// settings stand out.
+func configHash() uint32 {
+	hash := fnv.New32a()
+	// VisitAll walks the flags in lexicographical order
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(hash, "%s=%s\n", f.Name, f.Value.String())
+	})
+	// The flag only names the config file, so changing a target in it
+	// would otherwise leave the hash unchanged.
+	if *configFile != "" {
+		if data, err := os.ReadFile(*configFile); err == nil {
+			hash.Write(data)
+		}
+	}
+	return hash.Sum32()
+}
+
+// checkCredentials warns at startup about credentials that are likely to
+// fail to log in, rather than leaving it to a confusing scrape error.
+func checkCredentials(host string, user string, password string, authToken string) {
+	if authToken == "" {
+		log.Fatalf("Failed to build an auth token for %s", host)
+	}
+	log.Printf("Using a %d character auth token for %s", len(authToken), host)
+
+	if password == "" {
+		log.Printf("Warning: no password configured for %s, logging in as %q with an empty password", host, user)
+	}
+	for _, r := range password {
+		if r < ' ' || r > '~' {
+			log.Printf("Warning: password for %s contains control or non-ASCII characters, which the modem may encode differently", host)
+			break
+		}
+	}
+	// The token is sent unescaped in the query string, where some web
+	// servers decode '+' as a space.
+	if strings.Contains(authToken, "+") {
+		log.Printf("Warning: credentials for %s encode to an auth token containing '+', which the modem may not accept", host)
+	}
+}
+
+// newBackup builds the scraper for a backup modem using the settings given
+// on the command line.
+func newBackup(host string, user string, password string) *sb8200.Exporter {
+	backup := sb8200.NewExporter(host, user, password)
+	configureModem(backup)
+	checkCredentials(host, user, password, backup.AuthToken)
+	return backup
+}
+
+// configureModem applies the modem settings given on the command line.
+func configureModem(modem *sb8200.Exporter) {
+	modem.DiagnosticsPath = *diagnosticsPage
+	modem.EventLogPath = *eventLogPage
+	modem.EventLogMaxRows = *eventLogMaxRows
+	modem.SpectrumBins = *spectrumBins
+	modem.StructuredStatusPath = *structuredStatus
+	modem.LoginURL = *loginURL
+	modem.Headers = http.Header(requestHeaders)
+	modem.Method = *requestMethod
+	modem.LogoutMethod = *logoutMethod
+	modem.RequireUpstream = *requireUpstream
+	modem.RetryIncomplete = *retryIncomplete
+	modem.Scheme = *scheme
+	modem.H2C = *h2c
+	modem.Concurrency = *concurrency
+	modem.RequestTimeout = *requestTimeout
+	modem.ScrapeTimeout = *scrapeTimeout
+	modem.SessionTTL = *sessionTTL
+	modem.RootCAs = modemCAs
+}
+
+// loadCertPool reads the PEM encoded certificates of -modem.ca-cert.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+	return pool, nil
+}
+
+// parseBands parses -metrics.bands, e.g. "low:300,mid:700,high".
+func parseBands(value string) (bands []FrequencyBand, err error) {
+	if value == "" {
+		return nil, nil
+	}
+	entries := strings.Split(value, ",")
+	for i, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		band := FrequencyBand{Name: parts[0], UpperMHz: math.Inf(1)}
+		if band.Name == "" {
+			return nil, fmt.Errorf("band %d has no name", i+1)
+		}
+		if len(parts) == 2 {
+			if band.UpperMHz, err = strconv.ParseFloat(parts[1], 64); err != nil {
+				return nil, fmt.Errorf("band %s: %w", band.Name, err)
+			}
+		} else if i != len(entries)-1 {
+			return nil, fmt.Errorf("band %s needs an upper bound, only the last may omit it", band.Name)
+		}
+		if i > 0 && band.UpperMHz <= bands[i-1].UpperMHz {
+			return nil, fmt.Errorf("band %s must end above band %s", band.Name, bands[i-1].Name)
+		}
+		bands = append(bands, band)
+	}
+	return bands, nil
+}
+
+// checkConfig validates a config file for -test-config and returns the exit
+// code.
+func checkConfig(path string) int {
+	config, err := LoadConfig(path)
+	if err != nil {
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			errs = ConfigErrors{err}
+		}
+		fmt.Fprintf(os.Stderr, "%s: FAILED\n", path)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		return 1
+	}
+	fmt.Printf("%s: OK, %d targets\n", path, len(config.Targets))
+	return 0
+}
+
+// newExporter builds an Exporter for a modem using the settings given on the
+// command line, warning about credentials unlikely to work.
+func newExporter(host string, user string, password string) *Exporter {
+	exporter := buildExporter(host, user, password)
+	checkCredentials(host, user, password, exporter.AuthToken)
+	return exporter
+}
+
+// buildExporter builds an Exporter for a modem using the settings given on
+// the command line.
+func buildExporter(host string, user string, password string) *Exporter {
+	exporter := NewExporter(host, user, password)
+	configureModem(exporter.Exporter)
+	exporter.LockValueType = lockValueType
+	exporter.ChannelKey = *channelKey
+	exporter.RoundPrecision = *roundPrecision
+	exporter.Bands = frequencyBands
+	exporter.ExpectedSerial = *expectedSerial
+	exporter.ExpectedMAC = *expectedMAC
+	exporter.LogStaticInfo = *logStaticInfo
+	exporter.ObservationTimestamps = *observationTimestamps
+	exporter.HoldLastGood = *holdLastGood
+	exporter.RawValues = *rawValues
+	exporter.Maintenance = maintenance
+	exporter.StartupSplay = *startupSplay
+	exporter.WarmupScrapes = *warmupScrapes
+	exporter.ClockTolerance = *clockTolerance
+	exporter.Retries = *retryBudget
+	exporter.RetryWindow = *retryBudgetTime
+	if *powerHistogram {
+		exporter.PowerHistogram = downstreamPowerHistogram
+	}
+	if *uncorrectableHistogram {
+		exporter.UncorrectableHistogram = uncorrectableDeltaHistogram
+	}
+	exporter.PhaseHistogram = scrapePhaseHistogram
+	exporter.Recorder = recorder
+	return exporter
+}
+
+// applyTarget applies the settings a target in -config.file overrides.
+func applyTarget(exporter *Exporter, target TargetConfig) {
+	if target.LoginURL != "" {
+		exporter.LoginURL = target.LoginURL
+	}
+	if target.Scheme != "" {
+		exporter.Scheme = target.Scheme
+	}
+	if target.ExpectedSerial != "" {
+		exporter.ExpectedSerial = target.ExpectedSerial
+	}
+	if target.ExpectedMAC != "" {
+		exporter.ExpectedMAC = target.ExpectedMAC
+	}
+}
+
 func main() {
-	host := os.Getenv("ARRIS_CM_HOST")
-	user := "admin"
+	flag.Parse()
+	if err := setFlagsFromEnv(); err != nil {
+		log.Fatalf("Invalid environment variable %v", err)
+	}
+
+	switch *lockType {
+	case "gauge":
+		lockValueType = prometheus.GaugeValue
+	case "untyped":
+		lockValueType = prometheus.UntypedValue
+	default:
+		log.Fatalf("Invalid -metrics.lock-type %q, must be gauge or untyped", *lockType)
+	}
+	if *channelKey != ChannelKeyID && *channelKey != ChannelKeyIndex {
+		log.Fatalf("Invalid -metrics.channel-key %q, must be id or index", *channelKey)
+	}
+	if *verboseHelp {
+		buildDescriptors(true)
+	}
+	var err error
+	if frequencyBands, err = parseBands(*bands); err != nil {
+		log.Fatalf("Invalid -metrics.bands %q: %v", *bands, err)
+	}
+	if !methodRegexp.MatchString(*requestMethod) {
+		log.Fatalf("Invalid -modem.method %q, must be an upper case HTTP method such as GET or POST", *requestMethod)
+	}
+	if !methodRegexp.MatchString(*logoutMethod) {
+		log.Fatalf("Invalid -modem.logout-method %q, must be an upper case HTTP method such as GET or POST", *logoutMethod)
+	}
+	if *scheme != "http" && *scheme != "https" {
+		log.Fatalf("Invalid -modem.scheme %q, must be http or https", *scheme)
+	}
+	if *caCert != "" {
+		if modemCAs, err = loadCertPool(*caCert); err != nil {
+			log.Fatalf("Failed to load -modem.ca-cert %s: %v", *caCert, err)
+		}
+	}
+
+	if *testConfig != "" {
+		os.Exit(checkConfig(*testConfig))
+	}
+
+	maintenance.Set(*maintenanceFlag)
+	if *recordFile != "" {
+		recorder = &Recorder{Path: *recordFile, MaxBytes: *recordMaxBytes}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Read once at startup, a file that can't be read mustn't fall back to
+	// logging in with an empty password
 	password := os.Getenv("ARRIS_CM_PASSWORD")
+	if *passwordFile != "" {
+		if password, err = readFileValue(*passwordFile); err != nil {
+			log.Fatalf("Failed to read -modem.password-file: %v", err)
+		}
+	}
 
-	exporter := NewExporter(host, user, password)
-	prometheus.MustRegister(exporter)
+	var pollers sync.WaitGroup
+	var exporters Exporters
+	// Modems /probe may scrape, by host
+	probeTargets := make(map[string]*Exporter)
+	if *configFile != "" {
+		config, err := LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to load config file %s: %v", *configFile, err)
+		}
+		for _, target := range config.Targets {
+			exporter := newExporter(target.Host, target.Username, target.Password)
+			applyTarget(exporter, target)
+			probeTargets[target.Host] = exporter
+			if target.Backup != nil {
+				exporter.Backup = newBackup(target.Backup.Host, target.Backup.Username, target.Backup.Password)
+			}
+			exporters = append(exporters, exporter)
 
-	http.Handle(*metricsPath, promhttp.Handler())
+			pollers.Add(1)
+			go func(interval time.Duration) {
+				defer pollers.Done()
+				exporter.Poll(ctx, interval)
+			}(target.Interval)
+		}
+	} else {
+		host := os.Getenv("ARRIS_CM_HOST")
+		if *hostFile != "" {
+			var err error
+			if host, err = readFileValue(*hostFile); err != nil {
+				log.Fatalf("Failed to read -modem.host-file: %v", err)
+			}
+		}
+		user := *username
+		log.Printf("Logging in to %s as %q", host, user)
+
+		exporter := newExporter(host, user, password)
+		exporter.SessionCookie = *sessionCookie
+		probeTargets[host] = exporter
+		if backupHost := os.Getenv("ARRIS_CM_BACKUP_HOST"); backupHost != "" {
+			exporter.Backup = newBackup(backupHost, user, os.Getenv("ARRIS_CM_BACKUP_PASSWORD"))
+		}
+		exporters = append(exporters, exporter)
+	}
+	prometheus.MustRegister(exporters)
+	configHashGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_hash",
+		Help:      "Hash of the exporter's effective command line configuration",
+	})
+	configHashGauge.Set(float64(configHash()))
+	prometheus.MustRegister(configHashGauge)
+	if *powerHistogram {
+		prometheus.MustRegister(downstreamPowerHistogram)
+	}
+	if *uncorrectableHistogram {
+		prometheus.MustRegister(uncorrectableDeltaHistogram)
+	}
+	prometheus.MustRegister(scrapePhaseHistogram)
+
+	metricsHandler := promhttp.Handler()
+	if *etag {
+		metricsHandler = etagCache(exporters.cacheVersion, metricsHandler)
+	}
+	http.Handle(*metricsPath, allowCIDRs(allowedCIDRs, metricsHandler))
+	http.Handle("/debug/errors", allowCIDRs(allowedCIDRs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exporters.RecentErrors())
+	})))
+	if *debugStream {
+		http.Handle("/debug/stream", allowCIDRs(allowedCIDRs, http.HandlerFunc(exporters.ServeStream)))
+	}
+	http.Handle("/probe", allowCIDRs(allowedCIDRs, serveProbe(probeTargets)))
+	// Switching maintenance on silences alerting, so unless networks are
+	// allowed explicitly only the exporter's own host may do it
+	maintenanceCIDRs := allowedCIDRs
+	if len(maintenanceCIDRs) == 0 {
+		maintenanceCIDRs = loopbackCIDRs
+	}
+	http.Handle("/-/maintenance", allowCIDRs(maintenanceCIDRs, http.HandlerFunc(serveMaintenance)))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>Arris Cable Modem Exporter</title></head>
@@ -50,5 +603,31 @@ func main() {
 		</body>
 		</html>`))
 	})
-	log.Fatal(http.ListenAndServe(*listenAddress, handlers.LoggingHandler(os.Stdout, http.DefaultServeMux)))
+
+	server := &http.Server{
+		Addr:    *listenAddress,
+		Handler: handlers.LoggingHandler(os.Stdout, http.DefaultServeMux),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	// Bind before serving so a port conflict is reported as such rather
+	// than as a generic server error.
+	listener, err := net.Listen("tcp", *listenAddress)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listenAddress, err)
+	}
+	log.Printf("Listening on %s", listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	// Let in-flight background scrapes finish before exiting
+	pollers.Wait()
+	if recorder != nil {
+		recorder.Close()
+	}
 }
@@ -0,0 +1,30 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import "testing"
+
+func TestHeaderListStringSorted(t *testing.T) {
+	headers := headerList{
+		"X-B": {"2"},
+		"X-C": {"3"},
+		"X-A": {"1"},
+	}
+	for i := 0; i < 10; i++ {
+		if got, want := headers.String(), "X-A=1,X-B=2,X-C=3"; got != want {
+			t.Fatalf("String() = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// RequestTimings are summed over every HTTP request made during a scrape.
+type RequestTimings struct {
+	Connect  time.Duration // DNS lookup, TCP connect and TLS handshake
+	Transfer time.Duration // Everything else, from sending the request to reading the body
+	Requests int           // Number of requests made, including failed ones
+}
+
+// requestTimer accumulates RequestTimings for one scrape.
+type requestTimer struct {
+	mu      sync.Mutex
+	timings RequestTimings
+}
+
+func (t *requestTimer) add(connect time.Duration, transfer time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings.Connect += connect
+	t.timings.Transfer += transfer
+	t.timings.Requests++
+}
+
+func (t *requestTimer) Timings() RequestTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.timings
+}
+
+type requestTimerKey struct{}
+
+// withRequestTimer returns a context that records the timings of every
+// request made with it.
+func withRequestTimer(ctx context.Context) (context.Context, *requestTimer) {
+	timer := &requestTimer{}
+	return context.WithValue(ctx, requestTimerKey{}, timer), timer
+}
+
+// scheme returns the URL scheme of requests to the modem.
+func (e *Exporter) scheme() string {
+	if e.H2C {
+		return "http"
+	}
+	if e.Scheme != "" {
+		return e.Scheme
+	}
+	return "https"
+}
+
+// Endpoint returns the scheme, host and port requests to the modem go to. The
+// port defaults to the scheme's when Host doesn't name one.
+func (e *Exporter) Endpoint() (scheme string, host string, port string) {
+	scheme = e.scheme()
+	host, port, err := net.SplitHostPort(e.Host)
+	if err != nil {
+		host, port = e.Host, "443"
+		if scheme == "http" {
+			port = "80"
+		}
+	}
+	return
+}
+
+// httpClient returns the client shared by every request to the modem. Its
+// timeout backs up the scrape's context in case a request outlives it.
+func (e *Exporter) httpClient() *http.Client {
+	e.clientOnce.Do(func() {
+		if e.H2C {
+			// Prior knowledge HTTP/2 over plain TCP, there's no TLS
+			// handshake to negotiate it with.
+			e.client = &http.Client{
+				Timeout: e.ScrapeTimeout,
+				Transport: &http2.Transport{
+					AllowHTTP: true,
+					DialTLS: func(network string, addr string, _ *tls.Config) (net.Conn, error) {
+						return net.Dial(network, addr)
+					},
+				},
+			}
+			return
+		}
+		e.client = &http.Client{
+			Timeout: e.ScrapeTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: e.tlsConfig(),
+			},
+		}
+	})
+	return e.client
+}
+
+// tlsConfig returns the TLS configuration of connections to the modem. The
+// modem ships with a self-signed certificate, so it's only verified when
+// RootCAs pins one. Plain HTTP requests never use it.
+func (e *Exporter) tlsConfig() *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if e.RootCAs != nil {
+		config.VerifyConnection = e.verifyChain
+	}
+	return config
+}
+
+// verifyChain verifies the modem's certificate chains up to RootCAs. The
+// hostname isn't checked, as the modem's certificate doesn't name the address
+// it's reached at, so this replaces the default verification.
+func (e *Exporter) verifyChain(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("modem sent no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         e.RootCAs,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// ErrRequestTimeout is returned when a single request takes longer than
+// Exporter.RequestTimeout.
+var ErrRequestTimeout = errors.New("request timed out")
+
+// CloseIdleConnections closes the connections to the modem kept open for
+// reuse, for an Exporter that won't be used again.
+func (e *Exporter) CloseIdleConnections() {
+	e.httpClient().CloseIdleConnections()
+}
+
+// do sends req with the shared client and reads the whole response body. If
+// ctx carries a requestTimer the time spent is added to it.
+func (e *Exporter) do(ctx context.Context, req *http.Request) (resp *http.Response, body []byte, err error) {
+	for key, values := range e.Headers {
+		req.Header[key] = values
+	}
+
+	// A request of its own deadline, so a hung page can be told apart from
+	// the caller giving up on the whole scrape.
+	requestCtx := ctx
+	if e.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		requestCtx, cancel = context.WithTimeout(ctx, e.RequestTimeout)
+		defer cancel()
+		defer func() {
+			if err != nil && ctx.Err() == nil && errors.Is(requestCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("%w: %s took longer than %s", ErrRequestTimeout, req.URL.Path, e.RequestTimeout)
+			}
+		}()
+	}
+
+	var connect time.Duration
+	var getConn time.Time
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) { getConn = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				connect += time.Since(getConn)
+			}
+		},
+	}
+
+	start := time.Now()
+	defer func() {
+		if timer, ok := ctx.Value(requestTimerKey{}).(*requestTimer); ok {
+			timer.add(connect, time.Since(start)-connect)
+		}
+	}()
+
+	resp, err = e.httpClient().Do(req.WithContext(httptrace.WithClientTrace(requestCtx, trace)))
+	if err != nil {
+		return
+	}
+	defer drainAndClose(resp.Body)
+
+	// A connection dropped mid-read leaves a truncated body, which must not
+	// be mistaken for a complete response.
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response from %s: %w", req.URL.Path, err)
+	}
+	return
+}
+
+// drainAndClose reads whatever is left of a response body before closing it
+// so the connection can be reused.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, body)
+	body.Close()
+}
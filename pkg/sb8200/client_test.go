@@ -0,0 +1,108 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoTruncatedBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent, as a connection dropped mid-page does
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("<html>cut short"))
+	}))
+	defer server.Close()
+
+	e := &Exporter{Host: strings.TrimPrefix(server.URL, "http://"), Scheme: "http"}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/cmconnectionstatus.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, err := e.do(context.Background(), req)
+	if err == nil {
+		t.Fatalf("do returned %q without an error", body)
+	}
+	if body != nil {
+		t.Errorf("do returned the partial body %q", body)
+	}
+}
+
+// selfSignedCert returns a self-signed certificate like the modem's, naming
+// no IP address.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SB8200"},
+		DNSNames:              []string{"sb8200.local"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestPinnedCertificateWithoutIPSAN(t *testing.T) {
+	cert := selfSignedCert(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(pinned *x509.Certificate) error {
+		pool := x509.NewCertPool()
+		pool.AddCert(pinned)
+		e := &Exporter{Host: strings.TrimPrefix(server.URL, "https://"), RootCAs: pool}
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/cmconnectionstatus.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = e.do(context.Background(), req)
+		return err
+	}
+	if err := get(cert.Leaf); err != nil {
+		t.Errorf("pinning the modem's certificate: %v", err)
+	}
+	if err := get(selfSignedCert(t).Leaf); err == nil {
+		t.Error("a certificate other than the pinned one was accepted")
+	}
+}
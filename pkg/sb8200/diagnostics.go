@@ -0,0 +1,150 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type Diagnostics struct {
+	MemoryFreeBytes  float64       // Free memory (bytes), NaN when not reported
+	CPULoad          float64       // CPU load (percent), NaN when not reported
+	SpectrumAnalysis float64       // Is spectrum analysis enabled (boolean), NaN when not reported
+	Spectrum         []SpectrumBin // Spectrum power samples, only parsed when Exporter.SpectrumBins is set
+}
+
+type SpectrumBin struct {
+	Frequency float64 // Center frequency (Hz)
+	Power     float64 // Power level (dBmV)
+}
+
+var (
+	leadingNumberRegexp = regexp.MustCompile(`[-+]?\d+(\.\d+)?`)
+	byteUnitRegexp      = regexp.MustCompile(`(?i)\b([KMG]i?B|bytes?)\b`)
+	spectrumBinRegexp   = regexp.MustCompile(`(?i)^[\d.]+\s*[kmg]?hz$`)
+	byteUnitMultipliers = map[string]float64{
+		"kb": 1 << 10, "kib": 1 << 10,
+		"mb": 1 << 20, "mib": 1 << 20,
+		"gb": 1 << 30, "gib": 1 << 30,
+	}
+)
+
+// parseBytes converts a value like "51234 KB" into bytes. Values without a
+// unit are assumed to already be in bytes.
+func parseBytes(valStr string) (float64, error) {
+	num := leadingNumberRegexp.FindString(valStr)
+	if num == "" {
+		return 0, fmt.Errorf("no number in %q", valStr)
+	}
+	val, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	if unit := byteUnitRegexp.FindString(valStr); unit != "" {
+		if multiplier, ok := byteUnitMultipliers[strings.ToLower(unit)]; ok {
+			val *= multiplier
+		}
+	}
+	return val, nil
+}
+
+// ScrapeDiagnostics looks through every two column row of a diagnostics
+// page for free memory and CPU load readings. Rows it doesn't recognize are
+// ignored, so the fields stay NaN when the firmware doesn't report them.
+func ScrapeDiagnostics(document *goquery.Document) (diagnostics Diagnostics) {
+	diagnostics = Diagnostics{
+		MemoryFreeBytes:  math.NaN(),
+		CPULoad:          math.NaN(),
+		SpectrumAnalysis: math.NaN(),
+	}
+	document.Find("tr").Each(func(index int, element *goquery.Selection) {
+		label := strings.ToLower(strings.TrimSpace(ScrapeColStr(element, 1)))
+		value := ScrapeColStr(element, 2)
+		switch {
+		case strings.Contains(label, "memory") && strings.Contains(label, "free"):
+			if memFree, err := parseBytes(value); err == nil {
+				diagnostics.MemoryFreeBytes = memFree
+			}
+		case strings.Contains(label, "cpu"):
+			if num := leadingNumberRegexp.FindString(value); num != "" {
+				if load, err := strconv.ParseFloat(num, 64); err == nil {
+					diagnostics.CPULoad = load
+				}
+			}
+		case strings.Contains(label, "spectrum"):
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "enabled", "enable", "on":
+				diagnostics.SpectrumAnalysis = 1
+			case "disabled", "disable", "off":
+				diagnostics.SpectrumAnalysis = 0
+			}
+		}
+	})
+	return
+}
+
+// ScrapeSpectrum collects the spectrum analysis samples of a diagnostics
+// page: rows of a frequency (e.g. "255 MHz") and a power level in dBmV.
+// More than maxBins samples are thinned out evenly across the band to bound
+// the number of series.
+func ScrapeSpectrum(document *goquery.Document, maxBins int) (spectrum []SpectrumBin) {
+	document.Find("tr").Each(func(index int, element *goquery.Selection) {
+		frequencyStr := strings.TrimSpace(ScrapeColStr(element, 1))
+		powerStr := ScrapeColStr(element, 2)
+		if !spectrumBinRegexp.MatchString(frequencyStr) || !strings.Contains(strings.ToLower(powerStr), "dbmv") {
+			return
+		}
+		frequency, err := ParseFrequency(frequencyStr)
+		if err != nil {
+			return
+		}
+		power, err := parseLeadingFloat(powerStr)
+		if err != nil {
+			return
+		}
+		spectrum = append(spectrum, SpectrumBin{Frequency: frequency, Power: power})
+	})
+	if maxBins <= 0 || len(spectrum) <= maxBins {
+		return
+	}
+	thinned := make([]SpectrumBin, maxBins)
+	for i := range thinned {
+		thinned[i] = spectrum[i*len(spectrum)/maxBins]
+	}
+	return thinned
+}
+
+// scrapeDiagnostics fetches and parses the optional diagnostics page.
+func (e *Exporter) scrapeDiagnostics(ctx context.Context, sessionID *http.Cookie, csrfToken string) (diagnostics *Diagnostics, err error) {
+	url := fmt.Sprintf("%s://%s/%s?ct_%s", e.scheme(), e.Host, strings.TrimPrefix(e.DiagnosticsPath, "/"), csrfToken)
+	document, err := e.GetURL(ctx, url, sessionID)
+	if err != nil {
+		return
+	}
+	parsed := ScrapeDiagnostics(document)
+	if e.SpectrumBins > 0 {
+		parsed.Spectrum = ScrapeSpectrum(document, e.SpectrumBins)
+	}
+	diagnostics = &parsed
+	return
+}
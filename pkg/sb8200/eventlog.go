@@ -0,0 +1,181 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+type EventLog struct {
+	Events    []Event // Rows of the log, oldest first, at most Exporter.EventLogMaxRows
+	Truncated bool    // Whether older rows beyond EventLogMaxRows were left out
+}
+
+type Event struct {
+	Time        time.Time // When the event was logged, zero before the modem set its clock
+	ID          string    // DOCSIS event ID, e.g. "82000200"
+	Priority    string    // Event level as shown, e.g. "3"
+	Description string    // e.g. "No Ranging Response received - T3 time-out;..."
+}
+
+// timeoutEventRegexp matches the description of a DOCSIS T3 or T4 timeout
+// event, e.g. "No Ranging Response received - T3 time-out".
+var timeoutEventRegexp = regexp.MustCompile(`(?i)\bT([34])[\s-]*time[\s-]*out\b`)
+
+// Timeout returns 3 or 4 when the event is a T3 or T4 timeout, 0 otherwise.
+func (event Event) Timeout() int {
+	match := timeoutEventRegexp.FindStringSubmatch(event.Description)
+	if match == nil {
+		return 0
+	}
+	return int(match[1][0] - '0')
+}
+
+// eventTimeLayouts are the formats firmware shows event times in.
+var eventTimeLayouts = []string{
+	"01/02/2006 15:04:05",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+// parseEventTime parses the time of an event as UTC, like ParseSystemTime.
+// Rows logged before the modem set its clock read "Time Not Established" and
+// give the zero time.
+func parseEventTime(timeStr string) time.Time {
+	timeStr = strings.Join(strings.Fields(timeStr), " ")
+	for _, layout := range eventTimeLayouts {
+		if eventTime, err := time.Parse(layout, timeStr); err == nil {
+			return eventTime
+		}
+	}
+	return time.Time{}
+}
+
+// ParseEventLog parses the event log table, the one whose header names an
+// "Event Level" column. The modem appends new events at the bottom, so only
+// the last maxRows rows are kept, 0 for no limit. The page is tokenized
+// rather than parsed into a document, so a log that grew over months of
+// uptime costs one pass over the page and memory for at most twice maxRows
+// events. ok is false when the page has no event log table.
+func ParseEventLog(body []byte, maxRows int) (eventLog EventLog, ok bool) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	var (
+		cells       []string // Text of the cells of the current row
+		inRow       bool
+		inCell      bool
+		depth       int // Tables open
+		headerDepth int // depth of the event log table, once its header was seen
+	)
+	endRow := func() {
+		if !inRow {
+			return
+		}
+		if !ok {
+			if strings.Contains(strings.ToLower(strings.Join(cells, " ")), "event level") {
+				ok, headerDepth = true, depth
+			}
+		} else if len(cells) >= 4 {
+			eventLog.Events = append(eventLog.Events, Event{
+				Time:        parseEventTime(cells[0]),
+				ID:          strings.TrimSpace(cells[1]),
+				Priority:    strings.TrimSpace(cells[2]),
+				Description: strings.TrimSpace(cells[3]),
+			})
+			// Drop the older half at once rather than a row at a time
+			if maxRows > 0 && len(eventLog.Events) == 2*maxRows {
+				eventLog.Events = append(eventLog.Events[:0], eventLog.Events[maxRows:]...)
+				eventLog.Truncated = true
+			}
+		}
+		cells, inRow, inCell = nil, false, false
+	}
+	defer func() {
+		if maxRows > 0 && len(eventLog.Events) > maxRows {
+			eventLog.Events = eventLog.Events[len(eventLog.Events)-maxRows:]
+			eventLog.Truncated = true
+		}
+	}()
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			endRow()
+			return
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			start := tokenType == html.StartTagToken
+			switch string(name) {
+			case "table":
+				if start {
+					depth++
+					continue
+				}
+				endRow()
+				// The rows of the event log table end with it
+				if ok && depth == headerDepth {
+					return
+				}
+				depth--
+			case "tr":
+				endRow()
+				inRow = start
+			case "td", "th":
+				if start && inRow {
+					cells = append(cells, "")
+				}
+				inCell = start && inRow
+			}
+		case html.TextToken:
+			if inCell {
+				cells[len(cells)-1] += string(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// scrapeEventLog fetches and parses the event log page. It isn't parsed into
+// a document like the other pages, see ParseEventLog.
+func (e *Exporter) scrapeEventLog(ctx context.Context, sessionID *http.Cookie, csrfToken string) (eventLog *EventLog, err error) {
+	url := fmt.Sprintf("%s://%s/%s?ct_%s", e.scheme(), e.Host, strings.TrimPrefix(e.EventLogPath, "/"), csrfToken)
+	req, err := http.NewRequest(e.pageMethod(), url, nil)
+	if err != nil {
+		return
+	}
+	req.AddCookie(sessionID)
+
+	resp, body, err := e.do(ctx, req)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode == http.StatusUnauthorized || len(bytes.TrimSpace(body)) == 0 {
+		err = fmt.Errorf("%w: %s shows %s", ErrLoginRequired, req.URL.Path, resp.Request.URL.Path)
+		return
+	}
+	parsed, ok := ParseEventLog(body, e.EventLogMaxRows)
+	if !ok {
+		err = fmt.Errorf("%s has no event log table", e.EventLogPath)
+		return
+	}
+	eventLog = &parsed
+	return
+}
@@ -0,0 +1,97 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventLogPage renders an event log table of rows events, numbered from 1 in
+// the order the modem appends them.
+func eventLogPage(rows int) []byte {
+	var page strings.Builder
+	page.WriteString("<table><tr><th>Time</th><th>Priority</th><th>Event Level</th><th>Description</th></tr>")
+	for i := 1; i <= rows; i++ {
+		fmt.Fprintf(&page, "<tr><td>Time Not Established</td><td>%d</td><td>Critical (3)</td><td>event %d</td></tr>", i, i)
+	}
+	page.WriteString("</table><table><tr><td>1</td><td>2</td><td>3</td><td>not an event</td></tr></table>")
+	return []byte(page.String())
+}
+
+func TestParseEventLogMaxRows(t *testing.T) {
+	for _, test := range []struct {
+		rows, maxRows int
+		first, last   string
+		truncated     bool
+	}{
+		{rows: 10, maxRows: 0, first: "event 1", last: "event 10"},
+		{rows: 10, maxRows: 10, first: "event 1", last: "event 10"},
+		{rows: 10, maxRows: 3, first: "event 8", last: "event 10", truncated: true},
+		{rows: 10, maxRows: 4, first: "event 7", last: "event 10", truncated: true},
+		{rows: 10, maxRows: 5, first: "event 6", last: "event 10", truncated: true},
+	} {
+		eventLog, ok := ParseEventLog(eventLogPage(test.rows), test.maxRows)
+		if !ok {
+			t.Fatalf("maxRows %d: no event log table found", test.maxRows)
+		}
+		events := eventLog.Events
+		want := test.rows
+		if test.truncated {
+			want = test.maxRows
+		}
+		if len(events) != want || eventLog.Truncated != test.truncated {
+			t.Fatalf("maxRows %d: got %d events, truncated %v, want %d, %v", test.maxRows, len(events), eventLog.Truncated, want, test.truncated)
+		}
+		if events[0].Description != test.first || events[len(events)-1].Description != test.last {
+			t.Errorf("maxRows %d: got events %q to %q, want %q to %q", test.maxRows, events[0].Description, events[len(events)-1].Description, test.first, test.last)
+		}
+	}
+}
+
+func TestParseEventLogUnclosedRows(t *testing.T) {
+	page := `<table>
+<tr><th>Time<th>Priority<th>Event Level<th>Description
+<tr><td>01/02/2021 03:04:05<td>82000200<td>Critical (3)<td>No Ranging Response received - T3 time-out
+<tr><td>Time Not Established<td>68010300<td>Error (4)<td>DHCP RENEW WARNING
+</table>`
+	eventLog, ok := ParseEventLog([]byte(page), 0)
+	if !ok || len(eventLog.Events) != 2 {
+		t.Fatalf("got %d events, ok %v, want 2 events", len(eventLog.Events), ok)
+	}
+	first := eventLog.Events[0]
+	if want := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC); !first.Time.Equal(want) || first.ID != "82000200" || first.Priority != "Critical (3)" {
+		t.Errorf("got first event %+v, want logged at %v with ID 82000200 and level Critical (3)", first, want)
+	}
+	if _, ok := ParseEventLog([]byte("<table><tr><td>no log</td></tr></table>"), 0); ok {
+		t.Error("found an event log table in a page without one")
+	}
+}
+
+func TestEventTimeout(t *testing.T) {
+	for description, want := range map[string]int{
+		"No Ranging Response received - T3 time-out;CM-MAC=00:11:22:33:44:55;":                                                3,
+		"Received Response to Broadcast Maintenance Request, But no Unicast Maintenance opportunities received - T4 time out": 4,
+		"T3 Timeout": 3,
+		"Ranging Request Retries exhausted;CM-MAC=00:11:22:33:44:55;":                    0,
+		"SYNC Timing Synchronization failure - Failed to acquire QAM/QPSK symbol timing": 0,
+	} {
+		if got := (Event{Description: description}).Timeout(); got != want {
+			t.Errorf("Timeout() of %q = %d, want %d", description, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/prometheus/common/log"
+)
+
+// Modulation the channels of the OFDM and OFDMA tables are reported with,
+// the tables don't carry one comparable to the SC-QAM tables'.
+const (
+	ModulationOFDM  = "OFDM"
+	ModulationOFDMA = "OFDMA"
+)
+
+// ofdmColumns are the columns of an OFDM or OFDMA channel table, 0 when
+// missing. Their layout differs from the SC-QAM tables and between
+// firmware, so they're found by name in the column header row.
+type ofdmColumns struct {
+	channelID     int
+	lockStatus    int
+	profile       int
+	frequency     int
+	width         int
+	subcarriers   int
+	power         int
+	snr           int
+	corrected     int
+	uncorrectable int
+}
+
+func findOFDMColumns(header *goquery.Selection) ofdmColumns {
+	return ofdmColumns{
+		channelID:     headerColumn(header, "CHANNEL ID"),
+		lockStatus:    headerColumn(header, "LOCK"),
+		profile:       headerColumn(header, "PROFILE", "MODULATION"),
+		frequency:     headerColumn(header, "FREQ"),
+		width:         headerColumn(header, "WIDTH"),
+		subcarriers:   headerColumn(header, "SUBCARRIER"),
+		power:         headerColumn(header, "POWER"),
+		snr:           headerColumn(header, "SNR", "MER"),
+		corrected:     headerColumn(header, "CORRECTED"),
+		uncorrectable: headerColumn(header, "UNCORRECTABLE"),
+	}
+}
+
+// isOFDMHeaderRow reports whether a row is the title or column header row of
+// an OFDM or OFDMA table, rather than a channel.
+func isOFDMHeaderRow(row *goquery.Selection) bool {
+	firstVal := strings.TrimSpace(ScrapeColStr(row, 1))
+	return firstVal == "" || strings.EqualFold(firstVal, "Channel ID")
+}
+
+// ofdmTableKind returns ModulationOFDM or ModulationOFDMA for a table whose
+// title names it as such, "" for any other table.
+func ofdmTableKind(table *goquery.Selection) string {
+	title := strings.ToUpper(table.Find("tr").First().Text())
+	switch {
+	case strings.Contains(title, "OFDMA"):
+		return ModulationOFDMA
+	case strings.Contains(title, "OFDM"):
+		return ModulationOFDM
+	}
+	return ""
+}
+
+// ScrapeOFDMDownstreamTable parses the rows of a downstream OFDM channel
+// table. Rows missing a value every downstream channel has are skipped.
+func ScrapeOFDMDownstreamTable(rows *goquery.Selection) (downstreamChannels []DownstreamChannel) {
+	var columns ofdmColumns
+	rows.Each(func(index int, row *goquery.Selection) {
+		if isOFDMHeaderRow(row) {
+			if headerColumn(row, "CHANNEL ID") != 0 {
+				columns = findOFDMColumns(row)
+			}
+			return
+		}
+		channel, err := scrapeOFDMDownstreamRow(row, columns)
+		if err != nil {
+			log.Warnf("Skipping unparseable OFDM downstream row %d: %v", index, err)
+			return
+		}
+		downstreamChannels = append(downstreamChannels, channel)
+	})
+	return
+}
+
+func scrapeOFDMDownstreamRow(row *goquery.Selection, columns ofdmColumns) (channel DownstreamChannel, err error) {
+	channel = DownstreamChannel{
+		ChannelID:         strings.TrimSpace(ScrapeColStr(row, columns.channelID)),
+		LockStatus:        ofdmLockStatus(row, columns),
+		Modulation:        ModulationOFDM,
+		Frequency:         ofdmColStr(row, columns.frequency),
+		RawPower:          ofdmColStr(row, columns.power),
+		ProfileID:         math.NaN(),
+		Profile:           ofdmColStr(row, columns.profile),
+		ActiveSubcarriers: ofdmColStr(row, columns.subcarriers),
+	}
+	if channel.ChannelID == "" {
+		err = fmt.Errorf("no channel ID")
+		return
+	}
+	if channel.Power, err = ofdmValue(row, columns.power, "power"); err != nil {
+		return
+	}
+	if channel.SNR, err = ofdmValue(row, columns.snr, "SNR/MER"); err != nil {
+		return
+	}
+	if channel.CorrectedErrors, err = ofdmValue(row, columns.corrected, "corrected"); err != nil {
+		return
+	}
+	if channel.UncorrectableErrors, err = ofdmValue(row, columns.uncorrectable, "uncorrectables"); err != nil {
+		return
+	}
+	// The column lists every profile (e.g. "0,1,2") on some firmware, which
+	// says nothing about the active one
+	if profileID, profileErr := strconv.ParseFloat(channel.Profile, 64); profileErr == nil {
+		channel.ProfileID = profileID
+	}
+	return
+}
+
+// ScrapeOFDMAUpstreamTable parses the rows of an upstream OFDMA channel
+// table. Rows missing a value every upstream channel has are skipped.
+func ScrapeOFDMAUpstreamTable(rows *goquery.Selection) (upstreamChannels []UpstreamChannel) {
+	var columns ofdmColumns
+	rows.Each(func(index int, row *goquery.Selection) {
+		if isOFDMHeaderRow(row) {
+			if headerColumn(row, "CHANNEL ID") != 0 {
+				columns = findOFDMColumns(row)
+			}
+			return
+		}
+		channel, err := scrapeOFDMAUpstreamRow(row, columns)
+		if err != nil {
+			log.Warnf("Skipping unparseable OFDMA upstream row %d: %v", index, err)
+			return
+		}
+		upstreamChannels = append(upstreamChannels, channel)
+	})
+	return
+}
+
+func scrapeOFDMAUpstreamRow(row *goquery.Selection, columns ofdmColumns) (channel UpstreamChannel, err error) {
+	channel = UpstreamChannel{
+		ChannelID:         strings.TrimSpace(ScrapeColStr(row, columns.channelID)),
+		LockStatus:        ofdmLockStatus(row, columns),
+		USChannelType:     ModulationOFDMA,
+		Frequency:         ofdmColStr(row, columns.frequency),
+		Width:             ofdmColStr(row, columns.width),
+		RawPower:          ofdmColStr(row, columns.power),
+		SNR:               math.NaN(),
+		T3Timeouts:        math.NaN(),
+		T4Timeouts:        math.NaN(),
+		ActiveSubcarriers: ofdmColStr(row, columns.subcarriers),
+	}
+	if channel.ChannelID == "" {
+		err = fmt.Errorf("no channel ID")
+		return
+	}
+	if channel.Power, err = ofdmValue(row, columns.power, "power"); err != nil {
+		return
+	}
+	if columns.snr != 0 {
+		channel.SNR, err = ofdmValue(row, columns.snr, "SNR/MER")
+	}
+	return
+}
+
+// ofdmColStr returns the trimmed text of a column, "" when the table doesn't
+// have it.
+func ofdmColStr(row *goquery.Selection, column int) string {
+	if column == 0 {
+		return ""
+	}
+	return strings.TrimSpace(ScrapeColStr(row, column))
+}
+
+// ofdmValue parses the number in a column, failing when the table doesn't
+// have the column or the cell has no number.
+func ofdmValue(row *goquery.Selection, column int, name string) (float64, error) {
+	if column == 0 {
+		return 0, fmt.Errorf("table has no %s column", name)
+	}
+	value, err := ScrapeUnitValue(row, column)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return value, nil
+}
+
+func ofdmLockStatus(row *goquery.Selection, columns ofdmColumns) float64 {
+	if ofdmColStr(row, columns.lockStatus) == "Locked" {
+		return 1.
+	}
+	return 0.
+}
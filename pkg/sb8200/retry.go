@@ -0,0 +1,58 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is returned when a scrape has no retries left.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget is shared by every retry loop within a single scrape so that
+// nested retries (login, page fetches, ...) can't multiply into a request
+// storm against the modem.
+type RetryBudget struct {
+	mu       sync.Mutex
+	retries  int       // Retries left
+	deadline time.Time // No retries after this, zero for no time limit
+}
+
+// NewRetryBudget allows up to retries retries within window. A window of 0
+// doesn't limit the time spent retrying.
+func NewRetryBudget(retries int, window time.Duration) *RetryBudget {
+	budget := &RetryBudget{retries: retries}
+	if window > 0 {
+		budget.deadline = time.Now().Add(window)
+	}
+	return budget
+}
+
+// Take spends one retry, failing fast once the retries or time run out. A
+// nil budget never allows a retry.
+func (b *RetryBudget) Take() error {
+	if b == nil {
+		return ErrRetryBudgetExhausted
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.retries <= 0 || (!b.deadline.IsZero() && time.Now().After(b.deadline)) {
+		return ErrRetryBudgetExhausted
+	}
+	b.retries--
+	return nil
+}
@@ -0,0 +1,1264 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sb8200 scrapes status and product information from the web
+// interface of an Arris SB8200 cable modem.
+package sb8200
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/prometheus/common/log"
+	"golang.org/x/sync/errgroup"
+)
+
+type DownstreamChannel struct {
+	ChannelID           string  // Channel identifier (string)
+	LockStatus          float64 // Whether the channel is locked or not (boolean)
+	Modulation          string  // Type of modulation used by channel
+	Frequency           string  // Frequency the channel is operating on (Hz)
+	Power               float64 // Power level (dBmV)
+	RawPower            string  // Power level as shown by the modem, e.g. "3.4 dBmV"
+	SNR                 float64 // SNR/MER (dB)
+	CorrectedErrors     float64 // Counter, resets to 0 on modem reboot (n)
+	UncorrectableErrors float64 // Counter, resets to 0 on modem reboot (n)
+	ProfileID           float64 // Active OFDM profile, NaN when not reported
+	Profile             string  // Active OFDM profile as shown by the modem, e.g. "4096QAM", empty when not reported
+	ActiveSubcarriers   string  // Active subcarrier number range of OFDM channels, empty for SC-QAM
+}
+
+type UpstreamChannel struct {
+	Channel       string  // Channel Number (string)
+	ChannelID     string  // Channel ID (string)
+	LockStatus    float64 // Whether the channel is locked or not (boolean)
+	USChannelType string  // Upstream channel modulation
+	Frequency     string  // Frequency the channel is operating on (Hz)
+	Width         string  // Channel width (Hz)
+	Power         float64 // Power level (dBmV)
+	RawPower      string  // Power level as shown by the modem, e.g. "44.0 dBmV"
+	SNR           float64 // SNR/MER (dB), NaN on firmware that doesn't report it
+	T3Timeouts    float64 // Counter of ranging request timeouts, NaN on firmware that doesn't report it
+	T4Timeouts    float64 // Counter of station maintenance timeouts, NaN on firmware that doesn't report it
+
+	ActiveSubcarriers string // Active subcarrier number range of OFDMA channels, empty for SC-QAM
+}
+
+type StartupStep struct {
+	Procedure string  // Provisioning step, e.g. "Boot State"
+	Status    string  // Raw status column
+	Comment   string  // Raw comment column
+	OK        float64 // Did the step succeed (boolean)
+}
+
+type LockThreshold struct {
+	Name  string  // Row label, e.g. "Downstream Lock Threshold"
+	Value float64 // Threshold in Unit
+	Unit  string  // "dBmV" for power, "dB" for SNR
+}
+
+type ArrisModem struct {
+	Host                     string              // Hostname or network address of SB8200 modem
+	FirmwareFamily           string              // Layout the status page was parsed with, see ConnectionStatus
+	ConnectivityState        float64             // Is the modem connected to upstream provider (boolean)
+	RawConnectivityState     string              // From status page, e.g. "OK"
+	ConfigFileStatus         string              // From status page, empty when the firmware doesn't report it
+	DOCSISVersion            string              // From status page (e.g. "3.1"), empty when the firmware doesn't report it
+	Uptime                   float64             // From product info page, Uptime (Seconds)
+	HardwareVersion          string              // From product info page
+	SoftwareVersion          string              // From product info page
+	MACAddress               string              // From product info page
+	SerialNumber             string              // From product info page
+	MaxDownstreamChannels    float64             // From product info page (e.g. 32 of "32x8"), NaN when not reported
+	MaxUpstreamChannels      float64             // From product info page (e.g. 8 of "32x8"), NaN when not reported
+	StartupSteps             []StartupStep       // From status page, empty when the firmware doesn't report them
+	LockThresholds           []LockThreshold     // From status page, empty when the firmware doesn't report them
+	SystemTime               time.Time           // From status page, modem clock when the page was served, zero when not shown
+	DownstreamBondedChannels []DownstreamChannel // From status page, array of channels
+	UpstreamBondedChannels   []UpstreamChannel   // From status page, array of channels
+	ExpectedDownstream       float64             // From status page table title, NaN when not shown
+	ExpectedUpstream         float64             // From status page table title, NaN when not shown
+	Diagnostics              *Diagnostics        // From diagnostics page, nil when disabled or unavailable
+	EventLog                 *EventLog           // From event log page, nil when disabled or unavailable
+	Completeness             float64             // Fraction of the fields every firmware reports that were scraped, see completeness
+	RequestTimings           RequestTimings      // Summed over every request made by the scrape, set even on failure
+	Phases                   PhaseDurations      // Time spent in each phase of the scrape, set even on failure
+	Relogins                 int                 // Times the session was re-established after fetching pages, set even on failure
+	Partial                  bool                // The product information page timed out and its fields are empty, Uptime is NaN
+	ParseDuration            time.Duration       // Time spent parsing the fetched pages, excluding network time
+}
+
+// PhaseDurations break a scrape down into its phases. The status and product
+// information pages are fetched concurrently, so their phases overlap.
+// Phases the scrape didn't get to are 0.
+type PhaseDurations struct {
+	Login       time.Duration // Logging out of any previous session and logging in
+	FetchStatus time.Duration // Fetching the connection status, structured endpoint included
+	ParseStatus time.Duration // Parsing the HTML connection status page
+	FetchInfo   time.Duration // Fetching the product information page
+	ParseInfo   time.Duration // Parsing the product information page
+}
+
+type Exporter struct {
+	Host                 string         // Hostname or network address of SB8200 modem
+	AuthToken            string         // b64 encoded username:password
+	SessionCookie        string         // Optional sessionId obtained elsewhere, used instead of logging in until the modem rejects it
+	DiagnosticsPath      string         // Optional diagnostics page reporting memory/CPU, empty to skip
+	EventLogPath         string         // Optional event log page, empty to skip
+	EventLogMaxRows      int            // Newest event log rows kept at most, 0 for no limit
+	SpectrumBins         int            // Spectrum power samples kept from the diagnostics page, 0 to skip them
+	StructuredStatusPath string         // Optional XML/JSON status endpoint preferred over the HTML page
+	LoginURL             string         // Optional page to log in on when it differs from the status pages' scheme or host
+	Headers              http.Header    // Extra headers sent with every request to the modem
+	Method               string         // HTTP method of the page fetches, GET when empty
+	LogoutMethod         string         // HTTP method of the logout clearing any previous session before logging in, GET when empty
+	RequireUpstream      bool           // Fail scrapes that find no upstream channels
+	RetryIncomplete      bool           // Fetch the status page again when a table is shorter than its title says
+	Scheme               string         // URL scheme of the web interface, "http" for firmware without HTTPS, "https" when empty
+	H2C                  bool           // Speak cleartext HTTP/2 (h2c) instead of HTTPS, e.g. to a proxy in front of the modem
+	Retries              int            // Retries a single scrape may spend across all retry loops
+	RetryWindow          time.Duration  // Time after which a scrape stops retrying, 0 for no limit
+	Concurrency          int            // Pages fetched at once after logging in, 0 for no limit
+	RequestTimeout       time.Duration  // Abandon a single request after this long, 0 for no limit
+	ScrapeTimeout        time.Duration  // Abandon the whole scrape after this long, 0 for no limit
+	SessionTTL           time.Duration  // Reuse a login for scrapes within this long of it, 0 to log in on every scrape
+	RootCAs              *x509.CertPool // Verify the modem's certificate against these, nil to accept any certificate
+
+	clientOnce sync.Once
+	client     *http.Client // Shared by every request, see httpClient
+
+	// The last login, reused until sessionExpiry, see cachedSession, and
+	// logged out of by the next. sessionMu is also held while logging in so
+	// concurrent scrapes share one new session.
+	sessionMu     sync.Mutex
+	session       *http.Cookie
+	sessionToken  string
+	sessionExpiry time.Time
+	// The SessionCookie the modem rejected, no longer offered unless
+	// SessionCookie is changed.
+	rejectedCookie string
+}
+
+// NewExporter returns an Exporter that logs into the modem at host with the
+// given web interface credentials.
+func NewExporter(host string, user string, pass string) *Exporter {
+	return &Exporter{
+		Host:        host,
+		AuthToken:   b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, pass))),
+		Retries:     1,
+		Concurrency: 2,
+	}
+}
+
+// Log into the web interface and return sessionID and csrf token. The
+// previous session, nil if unknown, is logged out of first. Any retries are
+// drawn from budget.
+func (e *Exporter) Login(ctx context.Context, budget *RetryBudget, previous *http.Cookie) (sessionID *http.Cookie, csrfToken string, err error) {
+	logoutMethod := e.LogoutMethod
+	if logoutMethod == "" {
+		logoutMethod = http.MethodGet
+	}
+	req, err := http.NewRequest(logoutMethod, fmt.Sprintf("%s://%s/logout.html", e.scheme(), e.Host), nil)
+	if err != nil {
+		return
+	}
+	// Without the cookie the modem can't tell which session to end
+	if previous != nil {
+		req.AddCookie(previous)
+	}
+	logoutResp, _, err := e.do(ctx, req)
+	if err != nil {
+		err = fmt.Errorf("logging out of the previous session: %w", err)
+		return
+	}
+	// There may not have been a session to end, so carry on regardless,
+	// but a logout the modem refused explains a session conflict below.
+	if logoutResp.StatusCode >= http.StatusBadRequest {
+		log.Warnf("Logging out of the previous session on %s returned %s", e.Host, logoutResp.Status)
+	}
+
+	resp, body, err := e.requestLogin(ctx)
+	if err != nil {
+		return
+	}
+
+	// Some firmware answers the login with a 200 whose body is only a
+	// JavaScript redirect to the real login page. Visit the redirect target
+	// and ask for the csrf token again, once.
+	if target := jsRedirectTarget(body); resp.StatusCode == http.StatusOK && target != "" {
+		if err = budget.Take(); err != nil {
+			err = fmt.Errorf("login returned a JavaScript redirect: %w", err)
+			return
+		}
+		log.Debugf("Login returned a JavaScript redirect to %s, following it", target)
+		err = e.followRedirect(ctx, resp.Request.URL, target)
+		if err != nil {
+			return
+		}
+		resp, body, err = e.requestLogin(ctx)
+		if err != nil {
+			return
+		}
+		if jsRedirectTarget(body) != "" {
+			err = errors.New("login returned a JavaScript redirect instead of a csrf token")
+			return
+		}
+	}
+
+	// The modem intermittently answers with a 200 and no token at all, which
+	// would only make the page fetches fail. Ask again while the budget
+	// allows.
+	for resp.StatusCode == http.StatusOK && len(bytes.TrimSpace(body)) == 0 {
+		if err = budget.Take(); err != nil {
+			err = fmt.Errorf("login returned an empty csrf token: %w", err)
+			return
+		}
+		log.Debug("Login returned an empty csrf token, retrying")
+		resp, body, err = e.requestLogin(ctx)
+		if err != nil {
+			return
+		}
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		csrfToken = string(body)
+
+		for _, cookie := range resp.Cookies() {
+			// The server will set the sessionID to "" whenever it wants to
+			//   force and signal the end of a session.
+			if cookie.Name == "sessionId" && cookie.Value != "" {
+				sessionID = cookie
+				return
+			}
+		}
+
+		err = errors.New("missing sessionID")
+		return
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		err = errors.New("invalid credentials")
+		return
+	}
+
+	err = errors.New("unknown error/response code")
+	return
+}
+
+// requestLogin sends the credentials and returns the response along with
+// its fully read body.
+func (e *Exporter) requestLogin(ctx context.Context) (resp *http.Response, body []byte, err error) {
+	loginURL := e.LoginURL
+	if loginURL == "" {
+		loginURL = fmt.Sprintf("%s://%s/cmconnectionstatus.html", e.scheme(), e.Host)
+	}
+	url := fmt.Sprintf("%s?login_%s", loginURL, e.AuthToken)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	return e.do(ctx, req)
+}
+
+var jsRedirectRegexp = regexp.MustCompile(`(?is)^\s*<script[^>]*>\s*(?:window\.|document\.)?location(?:\.href)?\s*=\s*['"]([^'"]+)['"]`)
+
+// jsRedirectTarget returns where a body consisting of a JavaScript redirect
+// points to, or "" if the body isn't one.
+func jsRedirectTarget(body []byte) string {
+	match := jsRedirectRegexp.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
+
+// followRedirect requests target, resolved relative to base, and discards
+// the response.
+func (e *Exporter) followRedirect(ctx context.Context, base *neturl.URL, target string) error {
+	targetURL, err := base.Parse(target)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, targetURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	_, _, err = e.do(ctx, req)
+	return err
+}
+
+func ScrapeColStr(element *goquery.Selection, child int) string {
+	selectString := fmt.Sprintf("td:nth-child(%d)", child)
+	return element.Find(selectString).First().Text()
+}
+
+// commaDecimalRegexp matches a number using a comma as its decimal separator,
+// e.g. "3,4" or "-0,75". Three digits after the comma are a thousands
+// separator instead.
+var commaDecimalRegexp = regexp.MustCompile(`^([-+]?\d+),(\d{1,2})\b`)
+
+// pointThousandsRegexp matches a comma decimal number with point thousands
+// separators, e.g. "1.234,5".
+var pointThousandsRegexp = regexp.MustCompile(`^([-+]?\d{1,3}(?:\.\d{3})+),(\d+)\b`)
+
+// thousandsRegexp matches a number with comma thousands separators, e.g.
+// "1,234,567".
+var thousandsRegexp = regexp.MustCompile(`\d{1,3}(,\d{3})+\b`)
+
+// normalizeDecimal rewrites a leading comma decimal number, as rendered by
+// some non-US firmware, to use a point so strconv can parse it, and drops
+// thousands separators so that "1,234" isn't read as 1.
+func normalizeDecimal(valStr string) string {
+	valStr = strings.TrimSpace(valStr)
+	if match := pointThousandsRegexp.FindStringSubmatch(valStr); match != nil {
+		return strings.ReplaceAll(match[1], ".", "") + "." + match[2] + valStr[len(match[0]):]
+	}
+	valStr = commaDecimalRegexp.ReplaceAllString(valStr, "$1.$2")
+	return thousandsRegexp.ReplaceAllStringFunc(valStr, func(num string) string {
+		return strings.ReplaceAll(num, ",", "")
+	})
+}
+
+// ScrapeUnitValue parses the number in a cell like "3.4 dBmV", wherever the
+// unit and any padding (including non-breaking spaces) are.
+func ScrapeUnitValue(element *goquery.Selection, child int) (float64, error) {
+	return parseLeadingFloat(ScrapeColStr(element, child))
+}
+
+var frequencyUnitMultipliers = map[string]float64{
+	"hz": 1, "khz": 1e3, "mhz": 1e6, "ghz": 1e9,
+}
+
+// ParseFrequency converts a channel frequency like "495000000 Hz" or
+// "495 MHz" into Hz. Values without a unit are assumed to be in Hz.
+func ParseFrequency(valStr string) (float64, error) {
+	val, err := parseLeadingFloat(valStr)
+	if err != nil {
+		return 0, err
+	}
+	// Normalized first, as the comma of "3,4 MHz" would otherwise be left
+	// in front of the unit
+	unit := strings.ToLower(strings.TrimSpace(strings.TrimLeft(normalizeDecimal(valStr), "+-.0123456789")))
+	if multiplier, ok := frequencyUnitMultipliers[unit]; ok {
+		val *= multiplier
+	}
+	return val, nil
+}
+
+// ErrHeaderRow is returned by the row scrapers for a table's header rows.
+// They show up on every scrape, so the table scrapers skip them silently.
+var ErrHeaderRow = errors.New("table header row")
+
+func ScrapeDownstreamTableRow(element *goquery.Selection) (downstreamChannel DownstreamChannel, err error) {
+	// Skip the title and column header rows
+	if firstVal := ScrapeColStr(element, 1); firstVal == "Channel ID" || firstVal == "" {
+		err = ErrHeaderRow
+		return
+	}
+
+	lockStatus := 0.
+	if ScrapeColStr(element, 2) == "Locked" {
+		lockStatus = 1.
+	}
+
+	power, err := ScrapeUnitValue(element, 5)
+	if err != nil {
+		return
+	}
+
+	snr, err := ScrapeUnitValue(element, 6)
+	if err != nil {
+		return
+	}
+
+	correctedErrors, err := ScrapeUnitValue(element, 7)
+	if err != nil {
+		return
+	}
+
+	uncorrectableErrors, err := ScrapeUnitValue(element, 8)
+	if err != nil {
+		return
+	}
+
+	downstreamChannel = DownstreamChannel{
+		ChannelID:           ScrapeColStr(element, 1),
+		LockStatus:          lockStatus,
+		Modulation:          ScrapeColStr(element, 3),
+		Frequency:           ScrapeColStr(element, 4),
+		Power:               power,
+		RawPower:            ScrapeColStr(element, 5),
+		SNR:                 snr,
+		CorrectedErrors:     correctedErrors,
+		UncorrectableErrors: uncorrectableErrors,
+		ProfileID:           math.NaN(),
+	}
+	return
+}
+
+// headerColumn returns the first column of a table's column header row whose
+// name contains one of names, or 0 when there is none. It finds the optional
+// columns only some firmware adds.
+func headerColumn(element *goquery.Selection, names ...string) (column int) {
+	element.Find("td").EachWithBreak(func(index int, cell *goquery.Selection) bool {
+		header := strings.ToUpper(cell.Text())
+		for _, name := range names {
+			if strings.Contains(header, name) {
+				column = index + 1
+				return false
+			}
+		}
+		return true
+	})
+	return
+}
+
+func ScrapeDownstreamTable(element *goquery.Selection) (downstreamChannels []DownstreamChannel) {
+	profileColumn := 0
+	element.Each(func(index int, element *goquery.Selection) {
+		parsedRow, err := ScrapeDownstreamTableRow(element)
+		if errors.Is(err, ErrHeaderRow) {
+			if column := headerColumn(element, "PROFILE"); column != 0 {
+				profileColumn = column
+			}
+			return
+		}
+		if err != nil {
+			log.Warnf("Skipping unparseable downstream row %d: %v", index, err)
+			return
+		}
+		if profileColumn != 0 {
+			parsedRow.Profile = strings.TrimSpace(ScrapeColStr(element, profileColumn))
+			if profileID, err := parseLeadingFloat(parsedRow.Profile); err == nil {
+				parsedRow.ProfileID = profileID
+			}
+		}
+		downstreamChannels = append(downstreamChannels, parsedRow)
+	})
+	return
+}
+
+func ScrapeUpstreamTableRow(element *goquery.Selection) (upstreamChannel UpstreamChannel, err error) {
+	// Skip the title and column header rows
+	if firstVal := ScrapeColStr(element, 1); firstVal == "Channel" || firstVal == "" {
+		err = ErrHeaderRow
+		return
+	}
+
+	lockStatus := 0.
+	if ScrapeColStr(element, 3) == "Locked" {
+		lockStatus = 1.
+	}
+
+	power, err := ScrapeUnitValue(element, 7)
+	if err != nil {
+		return
+	}
+
+	upstreamChannel = UpstreamChannel{
+		Channel:       ScrapeColStr(element, 1),
+		ChannelID:     ScrapeColStr(element, 2),
+		LockStatus:    lockStatus,
+		USChannelType: ScrapeColStr(element, 4),
+		Frequency:     ScrapeColStr(element, 5),
+		Width:         ScrapeColStr(element, 6),
+		Power:         power,
+		RawPower:      ScrapeColStr(element, 7),
+		SNR:           math.NaN(),
+		T3Timeouts:    math.NaN(),
+		T4Timeouts:    math.NaN(),
+	}
+	return
+}
+
+func ScrapeUpstreamTable(element *goquery.Selection) (upstreamChannels []UpstreamChannel) {
+	snrColumn, t3Column, t4Column := 0, 0, 0
+	element.Each(func(index int, element *goquery.Selection) {
+		parsedRow, err := ScrapeUpstreamTableRow(element)
+		if errors.Is(err, ErrHeaderRow) {
+			if column := headerColumn(element, "SNR", "MER"); column != 0 {
+				snrColumn = column
+			}
+			if column := headerColumn(element, "T3 TIMEOUT"); column != 0 {
+				t3Column = column
+			}
+			if column := headerColumn(element, "T4 TIMEOUT"); column != 0 {
+				t4Column = column
+			}
+			return
+		}
+		if err != nil {
+			log.Warnf("Skipping unparseable upstream row %d: %v", index, err)
+			return
+		}
+		if snrColumn != 0 {
+			if snr, err := ScrapeUnitValue(element, snrColumn); err == nil {
+				parsedRow.SNR = snr
+			}
+		}
+		if t3Column != 0 {
+			if timeouts, err := parseLeadingFloat(ScrapeColStr(element, t3Column)); err == nil {
+				parsedRow.T3Timeouts = timeouts
+			}
+		}
+		if t4Column != 0 {
+			if timeouts, err := parseLeadingFloat(ScrapeColStr(element, t4Column)); err == nil {
+				parsedRow.T4Timeouts = timeouts
+			}
+		}
+		upstreamChannels = append(upstreamChannels, parsedRow)
+	})
+	return
+}
+
+// pageMethod returns the HTTP method used to fetch the modem's pages.
+func (e *Exporter) pageMethod() string {
+	if e.Method == "" {
+		return http.MethodGet
+	}
+	return e.Method
+}
+
+func (e *Exporter) GetURL(ctx context.Context, url string, sessionID *http.Cookie) (document *goquery.Document, err error) {
+	req, err := http.NewRequest(e.pageMethod(), url, nil)
+	if err != nil {
+		return
+	}
+	req.AddCookie(sessionID)
+
+	resp, body, err := e.do(ctx, req)
+	if err != nil {
+		return
+	}
+
+	document, err = goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	// The selectors would all miss on the wizard and report an empty but
+	// healthy looking modem.
+	if isSetupWizard(resp.Request.URL, document) {
+		err = fmt.Errorf("%w: %s shows %s", ErrSetupMode, req.URL.Path, resp.Request.URL.Path)
+		return
+	}
+	// Likewise when the session isn't accepted and the modem asks to log in,
+	// or on some firmware just serves an empty page.
+	if resp.StatusCode == http.StatusUnauthorized || len(bytes.TrimSpace(body)) == 0 || isLoginForm(resp.Request.URL, document) {
+		err = fmt.Errorf("%w: %s shows %s", ErrLoginRequired, req.URL.Path, resp.Request.URL.Path)
+	}
+	return
+}
+
+// ErrSetupMode is returned when the modem serves its setup wizard instead of
+// the requested page, as it does after a factory reset.
+var ErrSetupMode = errors.New("modem is in setup mode")
+
+// ErrLoginRequired is returned when the modem serves its login form, a 401
+// or an empty page instead of the requested page because the session isn't
+// valid.
+var ErrLoginRequired = errors.New("modem requires logging in")
+
+// Stages of a scrape that a ScrapeError attributes the failure to
+const (
+	StageLogin       = "login"
+	StageFetchStatus = "fetch_status"
+	StageFetchSWInfo = "fetch_swinfo"
+	StageParseUptime = "parse_uptime"
+	StageOther       = "other" // Failures not attributed to a stage, e.g. a missing upstream table
+)
+
+// ScrapeStages lists every stage ScrapeStage returns.
+var ScrapeStages = []string{StageLogin, StageFetchStatus, StageFetchSWInfo, StageParseUptime, StageOther}
+
+// ScrapeError is a failed scrape along with the stage it failed in.
+type ScrapeError struct {
+	Stage string
+	Err   error
+}
+
+func (err *ScrapeError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ScrapeError) Unwrap() error {
+	return err.Err
+}
+
+// PanicError is a panic recovered in one of the goroutines fetching a
+// scrape's pages, e.g. a parser choking on a malformed page. It's returned so
+// the scrape fails rather than the panic crashing the program, which a
+// recover in the caller of Scrape can't catch.
+type PanicError struct {
+	Value interface{} // Value passed to panic
+	Stack []byte      // Stack of the goroutine that panicked
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+// goRecover calls f in a goroutine of group, returning a panic in f as a
+// PanicError.
+func goRecover(group *errgroup.Group, f func() error) {
+	group.Go(func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		return f()
+	})
+}
+
+// ScrapeStage returns the stage a scrape failed in with err, StageOther
+// when err isn't a ScrapeError.
+func ScrapeStage(err error) string {
+	var scrapeErr *ScrapeError
+	if errors.As(err, &scrapeErr) {
+		return scrapeErr.Stage
+	}
+	return StageOther
+}
+
+var (
+	loginPathRegexp  = regexp.MustCompile(`(?i)login`)
+	loginTitleRegexp = regexp.MustCompile(`(?i)\blog ?in\b`)
+)
+
+// isLoginForm reports whether a page, given the URL it was finally served
+// from after any redirects, is the login form.
+func isLoginForm(pageURL *neturl.URL, document *goquery.Document) bool {
+	return loginPathRegexp.MatchString(pageURL.Path) ||
+		loginTitleRegexp.MatchString(document.Find("title").First().Text()) ||
+		document.Find(`input[type="password"]`).Length() > 0
+}
+
+var (
+	setupWizardPathRegexp  = regexp.MustCompile(`(?i)wizard`)
+	setupWizardTitleRegexp = regexp.MustCompile(`(?i)wizard|\bsetup\b`)
+)
+
+// isSetupWizard reports whether a page, given the URL it was finally served
+// from after any redirects, is the setup wizard.
+func isSetupWizard(pageURL *neturl.URL, document *goquery.Document) bool {
+	return setupWizardPathRegexp.MatchString(pageURL.Path) ||
+		setupWizardTitleRegexp.MatchString(document.Find("title").First().Text())
+}
+
+// Firmware families, the page layouts the status can be parsed from.
+const (
+	FirmwareFamilyHTML       = "html"       // cmconnectionstatus.html tables
+	FirmwareFamilyStructured = "structured" // XML/JSON status endpoint
+)
+
+// ConnectionStatus is the data read from the connection status page.
+type ConnectionStatus struct {
+	FirmwareFamily       string              // Layout the status was parsed from, one of the FirmwareFamily constants
+	ConnectivityState    float64             // Is the modem connected to upstream provider (boolean)
+	RawConnectivityState string              // Connectivity state as shown by the modem, e.g. "OK"
+	ConfigFileStatus     string              // Empty when the firmware doesn't report it
+	DOCSISVersion        string              // Active DOCSIS mode (e.g. "3.1"), empty when not reported
+	StartupSteps         []StartupStep       // Startup procedure table
+	LockThresholds       []LockThreshold     // Power/SNR limits the modem locks channels with
+	DownstreamChannels   []DownstreamChannel // Bonded downstream channels
+	UpstreamChannels     []UpstreamChannel   // Bonded upstream channels
+	SystemTime           time.Time           // Modem clock at the time of the scrape, zero when not shown
+
+	// Channel counts shown in the table titles, NaN when not shown
+	ExpectedDownstreamChannels float64
+	ExpectedUpstreamChannels   float64
+}
+
+// Incomplete reports whether a table has fewer rows than its title says it
+// should, as happens when the modem serves a page it is still rendering.
+func (status ConnectionStatus) Incomplete() bool {
+	return float64(len(status.DownstreamChannels)) < status.ExpectedDownstreamChannels ||
+		float64(len(status.UpstreamChannels)) < status.ExpectedUpstreamChannels
+}
+
+// tableCountRegexp matches a channel count in a table title, e.g. the 24 of
+// "Downstream Bonded Channels (24)".
+var tableCountRegexp = regexp.MustCompile(`\(\s*(\d+)\s*\)`)
+
+// tableTitleCount returns the channel count in the title row of a table, NaN
+// when there is none.
+func tableTitleCount(table *goquery.Selection) float64 {
+	match := tableCountRegexp.FindStringSubmatch(table.Find("tr").First().Text())
+	if match == nil {
+		return math.NaN()
+	}
+	count, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return count
+}
+
+// startupOKValues are the statuses and comments of a successful startup step.
+// Some steps report a value (e.g. a frequency) as their status and the
+// outcome as their comment.
+var startupOKValues = map[string]bool{
+	"ok": true, "locked": true, "enabled": true, "allowed": true, "operational": true,
+}
+
+// ScrapeStartupTable reads the "Startup Procedure" table, whose procedure,
+// status and comment columns differ from the channel tables.
+func ScrapeStartupTable(rows *goquery.Selection) (steps []StartupStep) {
+	rows.Each(func(index int, element *goquery.Selection) {
+		procedure := strings.TrimSpace(ScrapeColStr(element, 1))
+		// Skip the title and column header rows
+		if procedure == "Procedure" || procedure == "" {
+			return
+		}
+		step := StartupStep{
+			Procedure: procedure,
+			Status:    strings.TrimSpace(ScrapeColStr(element, 2)),
+			Comment:   strings.TrimSpace(ScrapeColStr(element, 3)),
+		}
+		if startupOKValues[strings.ToLower(step.Status)] || startupOKValues[strings.ToLower(step.Comment)] {
+			step.OK = 1.
+		}
+		steps = append(steps, step)
+	})
+	return
+}
+
+var lockThresholdRegexp = regexp.MustCompile(`([-+]?\d+(?:\.\d+)?)\s*(dBmV|dB)\b`)
+
+// ParseLockThresholds looks for rows reporting the power or SNR thresholds
+// the modem itself locks channels with, e.g. "Downstream Lock Threshold".
+func ParseLockThresholds(document *goquery.Document) (thresholds []LockThreshold) {
+	document.Find("tr").Each(func(index int, element *goquery.Selection) {
+		name := strings.TrimSpace(ScrapeColStr(element, 1))
+		label := strings.ToLower(name)
+		if !strings.Contains(label, "threshold") && !strings.Contains(label, "tolerance") {
+			return
+		}
+		match := lockThresholdRegexp.FindStringSubmatch(ScrapeColStr(element, 2))
+		if match == nil {
+			return
+		}
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return
+		}
+		thresholds = append(thresholds, LockThreshold{Name: name, Value: value, Unit: match[2]})
+	})
+	return
+}
+
+var docsisVersionRegexp = regexp.MustCompile(`\d+\.\d+`)
+
+// parseDOCSISVersion returns the version from a "DOCSIS Mode"/"DOCSIS
+// Version" row, or "" if the row is something else.
+func parseDOCSISVersion(label string, value string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if !strings.HasPrefix(label, "docsis") || !(strings.Contains(label, "mode") || strings.Contains(label, "version")) {
+		return ""
+	}
+	return docsisVersionRegexp.FindString(value)
+}
+
+var channelCapacityRegexp = regexp.MustCompile(`(\d+)\s*[xX]\s*(\d+)`)
+
+// ParseChannelCapacity looks for the maximum number of bondable channels,
+// reported by some firmware as a "32x8" style value in a channel bonding row
+// of the product info page. Both are NaN when it isn't reported.
+func ParseChannelCapacity(document *goquery.Document) (downstream float64, upstream float64) {
+	downstream, upstream = math.NaN(), math.NaN()
+	document.Find("tr").EachWithBreak(func(index int, element *goquery.Selection) bool {
+		label := strings.ToLower(ScrapeColStr(element, 1))
+		if !strings.Contains(label, "channel") && !strings.Contains(label, "bonding") {
+			return true
+		}
+		match := channelCapacityRegexp.FindStringSubmatch(ScrapeColStr(element, 2))
+		if match == nil {
+			return true
+		}
+		downstream, _ = strconv.ParseFloat(match[1], 64)
+		upstream, _ = strconv.ParseFloat(match[2], 64)
+		return false
+	})
+	return
+}
+
+// ParseStatusPage reads the connection status from the HTML status page.
+func ParseStatusPage(document *goquery.Document) (status ConnectionStatus) {
+	status.FirmwareFamily = FirmwareFamilyHTML
+	status.ExpectedDownstreamChannels = math.NaN()
+	status.ExpectedUpstreamChannels = math.NaN()
+
+	connectivityStateSelector := ".content > center:nth-child(2) > table:nth-child(1) > tbody:nth-child(1) > tr:nth-child(4) > td:nth-child(2)"
+	status.RawConnectivityState = strings.TrimSpace(document.Find(connectivityStateSelector).First().Text())
+	if status.RawConnectivityState == "OK" {
+		status.ConnectivityState = 1.
+	}
+
+	configFileSelector := ".content > center:nth-child(2) > table:nth-child(1) > tbody:nth-child(1) > tr:nth-child(6)"
+	if configFileRow := document.Find(configFileSelector).First(); ScrapeColStr(configFileRow, 1) == "Configuration File" {
+		status.ConfigFileStatus = ScrapeColStr(configFileRow, 2)
+	}
+
+	document.Find("tr").EachWithBreak(func(i int, element *goquery.Selection) bool {
+		status.DOCSISVersion = parseDOCSISVersion(ScrapeColStr(element, 1), ScrapeColStr(element, 2))
+		return status.DOCSISVersion == ""
+	})
+
+	status.LockThresholds = ParseLockThresholds(document)
+	status.SystemTime = ParseSystemTime(document)
+
+	var ofdmTables []*goquery.Selection
+	document.Find("table").Each(func(i int, element *goquery.Selection) {
+		switch i {
+		case 0:
+			status.StartupSteps = ScrapeStartupTable(element.Find("tr"))
+		case 1:
+			status.DownstreamChannels = ScrapeDownstreamTable(element.Find("tr"))
+			status.ExpectedDownstreamChannels = tableTitleCount(element)
+		case 2:
+			status.UpstreamChannels = ScrapeUpstreamTable(element.Find("tr"))
+			status.ExpectedUpstreamChannels = tableTitleCount(element)
+		default:
+			ofdmTables = append(ofdmTables, element)
+		}
+	})
+
+	// Firmware listing OFDM/OFDMA channels in tables of their own has them
+	// after the SC-QAM tables. The title counts only cover the SC-QAM
+	// tables, so the OFDM rows are added to the expected counts.
+	for _, table := range ofdmTables {
+		switch ofdmTableKind(table) {
+		case ModulationOFDM:
+			channels := ScrapeOFDMDownstreamTable(table.Find("tr"))
+			status.DownstreamChannels = append(status.DownstreamChannels, channels...)
+			status.ExpectedDownstreamChannels += float64(len(channels))
+		case ModulationOFDMA:
+			channels := ScrapeOFDMAUpstreamTable(table.Find("tr"))
+			status.UpstreamChannels = append(status.UpstreamChannels, channels...)
+			status.ExpectedUpstreamChannels += float64(len(channels))
+		}
+	}
+	return
+}
+
+var systemTimeRegexp = regexp.MustCompile(`Current System Time:\s*(\w{3} \w{3}\s+\d{1,2} \d{1,2}:\d{2}:\d{2} \d{4})`)
+
+// ParseSystemTime reads the modem clock from the "Current System Time: Mon
+// Jun 28 17:22:33 2021" line of the status page. The modem sets its clock
+// from the CMTS, which serves UTC, so the time is taken to be UTC. It returns
+// the zero time when the line is missing or doesn't parse.
+func ParseSystemTime(document *goquery.Document) time.Time {
+	match := systemTimeRegexp.FindStringSubmatch(document.Text())
+	if match == nil {
+		return time.Time{}
+	}
+	// Single digit days are padded with a space, collapse it so one layout
+	// covers both
+	systemTime, err := time.Parse("Mon Jan 2 15:04:05 2006", strings.Join(strings.Fields(match[1]), " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return systemTime
+}
+
+// Scrape the web page for metric data
+func (e *Exporter) Scrape() (modem ArrisModem, err error) {
+	ctx, timer := withRequestTimer(context.Background())
+	if e.ScrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.ScrapeTimeout)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("scrape of %s timed out after %s: %w", e.Host, e.ScrapeTimeout, err)
+			}
+		}()
+	}
+	var phases PhaseDurations
+	var relogins int
+	defer func() {
+		modem.RequestTimings = timer.Timings()
+		modem.Phases = phases
+		modem.Relogins = relogins
+	}()
+
+	budget := NewRetryBudget(e.Retries, e.RetryWindow)
+	var sessionID *http.Cookie
+	var csrfToken string
+	login := func() (err error) {
+		loginStart := time.Now()
+		sessionID, csrfToken, err = e.loginSession(ctx, budget)
+		phases.Login += time.Since(loginStart)
+		if err != nil {
+			log.Error("Failed to fetch login tokens")
+			err = &ScrapeError{Stage: StageLogin, Err: err}
+		}
+		return
+	}
+
+	sessionID, csrfToken = e.cachedSession()
+	reused := sessionID != nil
+	if !reused {
+		if err = login(); err != nil {
+			return
+		}
+	}
+
+	status, info, diagnostics, eventLog, err := e.fetchPages(ctx, sessionID, csrfToken, &phases)
+	// The modem ends sessions without notice, even one logged in to just
+	// now, so it's worth a single new login before failing the scrape.
+	// Logging in again after this scrape's own login is a retry, drawn from
+	// budget like the others, where a session taken over from an earlier
+	// scrape ending only makes this the scrape's first login.
+	if errors.Is(err, ErrLoginRequired) {
+		if !reused {
+			if budgetErr := budget.Take(); budgetErr != nil {
+				err = &ScrapeError{Stage: ScrapeStage(err), Err: fmt.Errorf("%v, and logging in again: %w", err, budgetErr)}
+				return
+			}
+		}
+		if reused && sessionID.Value == e.SessionCookie {
+			log.Warnf("Modem %s rejected the supplied session cookie, logging in instead", e.Host)
+		} else {
+			log.Infof("Modem %s ended the session, logging in again: %v", e.Host, err)
+		}
+		e.forgetSession(sessionID)
+		relogins++
+		if err = login(); err != nil {
+			return
+		}
+		status, info, diagnostics, eventLog, err = e.fetchPages(ctx, sessionID, csrfToken, &phases)
+	}
+	if err != nil {
+		return
+	}
+
+	if e.RetryIncomplete && status.Incomplete() {
+		if budgetErr := budget.Take(); budgetErr != nil {
+			log.Warnf("Status page of %s is incomplete and can't be fetched again: %v", e.Host, budgetErr)
+		} else {
+			log.Debugf("Status page of %s is incomplete, fetching it again", e.Host)
+			var fetch, parse time.Duration
+			status, fetch, parse, err = e.scrapeStatus(ctx, sessionID, csrfToken)
+			phases.FetchStatus += fetch
+			phases.ParseStatus += parse
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// The upstream table is missing in some provisioning states, which is
+	// only an error for links that should always have one.
+	if e.RequireUpstream && len(status.UpstreamChannels) == 0 {
+		err = errors.New("status page has no upstream channels")
+		return
+	}
+
+	modem = info
+	modem.Host = e.Host
+	modem.FirmwareFamily = status.FirmwareFamily
+	modem.ConnectivityState = status.ConnectivityState
+	modem.RawConnectivityState = status.RawConnectivityState
+	modem.ConfigFileStatus = status.ConfigFileStatus
+	modem.DOCSISVersion = status.DOCSISVersion
+	modem.StartupSteps = status.StartupSteps
+	modem.LockThresholds = status.LockThresholds
+	modem.SystemTime = status.SystemTime
+	modem.DownstreamBondedChannels = status.DownstreamChannels
+	modem.UpstreamBondedChannels = status.UpstreamChannels
+	modem.ExpectedDownstream = status.ExpectedDownstreamChannels
+	modem.ExpectedUpstream = status.ExpectedUpstreamChannels
+	modem.Diagnostics = diagnostics
+	modem.EventLog = eventLog
+	modem.ParseDuration = phases.ParseStatus + phases.ParseInfo
+	modem.Completeness = modem.completeness()
+	return
+}
+
+// cachedSession returns the session of a previous login that hasn't reached
+// SessionTTL, or else the SessionCookie given, or nil to log in.
+func (e *Exporter) cachedSession() (sessionID *http.Cookie, csrfToken string) {
+	e.sessionMu.Lock()
+	defer e.sessionMu.Unlock()
+	if e.session != nil && time.Now().Before(e.sessionExpiry) {
+		return e.session, e.sessionToken
+	}
+	if e.SessionCookie != "" && e.SessionCookie != e.rejectedCookie {
+		return &http.Cookie{Name: "sessionId", Value: e.SessionCookie}, ""
+	}
+	return nil, ""
+}
+
+// loginSession logs in, ending the previous session, and caches the new one
+// for SessionTTL. A scrape that
+// waited for a concurrent one to log in takes over its session instead, as
+// logging in again would end it.
+func (e *Exporter) loginSession(ctx context.Context, budget *RetryBudget) (sessionID *http.Cookie, csrfToken string, err error) {
+	e.sessionMu.Lock()
+	defer e.sessionMu.Unlock()
+	if e.session != nil && time.Now().Before(e.sessionExpiry) {
+		return e.session, e.sessionToken, nil
+	}
+	loginTime := time.Now()
+	sessionID, csrfToken, err = e.Login(ctx, budget, e.session)
+	if err != nil {
+		return
+	}
+	// The session is kept even when it isn't reused, for the next login to
+	// log out of
+	e.session, e.sessionToken, e.sessionExpiry = sessionID, csrfToken, time.Time{}
+	if e.SessionTTL > 0 {
+		e.sessionExpiry = loginTime.Add(e.SessionTTL)
+	}
+	return
+}
+
+// forgetSession drops the cached session if it's still sessionID, which the
+// modem rejected. A newer session another scrape logged in to is kept. A
+// rejected SessionCookie isn't offered again.
+func (e *Exporter) forgetSession(sessionID *http.Cookie) {
+	e.sessionMu.Lock()
+	defer e.sessionMu.Unlock()
+	if sessionID.Value == e.SessionCookie {
+		e.rejectedCookie = e.SessionCookie
+	}
+	if e.session == sessionID {
+		e.session, e.sessionToken = nil, ""
+	}
+}
+
+// completeness returns the fraction of the fields every firmware reports
+// that were populated. Selectors that miss leave fields empty rather than
+// failing the scrape, so this flags a page whose layout drifted. Fields
+// documented as not reported by every firmware aren't counted.
+func (modem ArrisModem) completeness() float64 {
+	fields := []bool{
+		modem.RawConnectivityState != "",
+		modem.HardwareVersion != "",
+		modem.SoftwareVersion != "",
+		modem.MACAddress != "",
+		modem.SerialNumber != "",
+		len(modem.DownstreamBondedChannels) > 0,
+		len(modem.UpstreamBondedChannels) > 0,
+	}
+	populated := 0
+	for _, ok := range fields {
+		if ok {
+			populated++
+		}
+	}
+	return float64(populated) / float64(len(fields))
+}
+
+// fetchPages fetches and parses the pages of a scrape over a session. The
+// pages are independent once logged in, so they're fetched concurrently.
+func (e *Exporter) fetchPages(ctx context.Context, sessionID *http.Cookie, csrfToken string, phases *PhaseDurations) (status ConnectionStatus, info ArrisModem, diagnostics *Diagnostics, eventLog *EventLog, err error) {
+	group, groupCtx := errgroup.WithContext(ctx)
+	if e.Concurrency > 0 {
+		group.SetLimit(e.Concurrency)
+	}
+	goRecover(group, func() (err error) {
+		status, phases.FetchStatus, phases.ParseStatus, err = e.scrapeStatus(groupCtx, sessionID, csrfToken)
+		return
+	})
+	goRecover(group, func() (err error) {
+		phases.FetchInfo, phases.ParseInfo, err = e.scrapeProductInfo(groupCtx, sessionID, csrfToken, &info)
+		// The channel data is on the status page, so a product information
+		// page that hangs is left out rather than failing the scrape.
+		if errors.Is(err, ErrRequestTimeout) {
+			log.Warnf("Leaving out the product information of %s: %v", e.Host, err)
+			info = ArrisModem{
+				Partial:               true,
+				Uptime:                math.NaN(),
+				MaxDownstreamChannels: math.NaN(),
+				MaxUpstreamChannels:   math.NaN(),
+			}
+			err = nil
+		}
+		return
+	})
+	// The diagnostics page is hidden and not present on every firmware, so
+	// failing to read it shouldn't fail the scrape.
+	if e.DiagnosticsPath != "" {
+		goRecover(group, func() error {
+			var diagErr error
+			diagnostics, diagErr = e.scrapeDiagnostics(groupCtx, sessionID, csrfToken)
+			if diagErr != nil {
+				log.Warnf("Failed to fetch diagnostics page: %v", diagErr)
+			}
+			return nil
+		})
+	}
+	// Likewise the event log, which is missing or empty on some firmware.
+	// It's fetched by default, so this isn't worth a warning every scrape.
+	if e.EventLogPath != "" {
+		goRecover(group, func() error {
+			var eventLogErr error
+			eventLog, eventLogErr = e.scrapeEventLog(groupCtx, sessionID, csrfToken)
+			if eventLogErr != nil {
+				log.Debugf("Failed to fetch event log page: %v", eventLogErr)
+			}
+			return nil
+		})
+	}
+	err = group.Wait()
+	return
+}
+
+// parseStatusPage is ParseStatusPage, replaced in tests.
+var parseStatusPage = ParseStatusPage
+
+// scrapeStatus fetches and parses the connection status, from the structured
+// endpoint when configured and available, otherwise from the HTML page.
+func (e *Exporter) scrapeStatus(ctx context.Context, sessionID *http.Cookie, csrfToken string) (status ConnectionStatus, fetchDuration time.Duration, parseDuration time.Duration, err error) {
+	fetchStart := time.Now()
+	if e.StructuredStatusPath != "" {
+		var structuredErr error
+		status, structuredErr = e.scrapeStructuredStatus(ctx, sessionID, csrfToken)
+		if structuredErr == nil {
+			fetchDuration = time.Since(fetchStart)
+			return
+		}
+		log.Debugf("Falling back to the HTML status page: %v", structuredErr)
+	}
+
+	url := fmt.Sprintf("%s://%s/cmconnectionstatus.html?ct_%s", e.scheme(), e.Host, csrfToken)
+	document, err := e.GetURL(ctx, url, sessionID)
+	fetchDuration = time.Since(fetchStart)
+	if err != nil {
+		log.Error("Failed to fetch connection status url")
+		err = &ScrapeError{Stage: StageFetchStatus, Err: err}
+		return
+	}
+	parseStart := time.Now()
+	status = parseStatusPage(document)
+	parseDuration = time.Since(parseStart)
+	return
+}
+
+// scrapeProductInfo fetches the product information page and fills in the
+// fields of modem it carries.
+func (e *Exporter) scrapeProductInfo(ctx context.Context, sessionID *http.Cookie, csrfToken string, modem *ArrisModem) (fetchDuration time.Duration, parseDuration time.Duration, err error) {
+	url := fmt.Sprintf("%s://%s/cmswinfo.html?ct_%s", e.scheme(), e.Host, csrfToken)
+	fetchStart := time.Now()
+	document, err := e.GetURL(ctx, url, sessionID)
+	fetchDuration = time.Since(fetchStart)
+	if err != nil {
+		log.Error("Failed to fetch product information page")
+		err = &ScrapeError{Stage: StageFetchSWInfo, Err: err}
+		return
+	}
+
+	parseStart := time.Now()
+	defer func() {
+		parseDuration = time.Since(parseStart)
+	}()
+
+	hwVerSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(3) > td:nth-child(2)"
+	modem.HardwareVersion = document.Find(hwVerSelector).First().Text()
+
+	swVerSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(4) > td:nth-child(2)"
+	modem.SoftwareVersion = document.Find(swVerSelector).First().Text()
+
+	macAddrSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(5) > td:nth-child(2)"
+	modem.MACAddress = document.Find(macAddrSelector).First().Text()
+
+	serialSelector := "table.simpleTable:nth-child(2) > tbody:nth-child(1) > tr:nth-child(6) > td:nth-child(2)"
+	modem.SerialNumber = document.Find(serialSelector).First().Text()
+
+	modem.MaxDownstreamChannels, modem.MaxUpstreamChannels = ParseChannelCapacity(document)
+
+	uptimeSelector := "table.simpleTable:nth-child(5) > tbody:nth-child(1) > tr:nth-child(2) > td:nth-child(2)"
+	uptimeStr := document.Find(uptimeSelector).First().Text()
+	var format string
+	modem.Uptime, format, err = ParseUptime(uptimeStr)
+	if err != nil {
+		err = &ScrapeError{Stage: StageParseUptime, Err: err}
+		return
+	}
+	log.Debugf("Parsed uptime %q of %s as %s", uptimeStr, e.Host, format)
+	return
+}
+
+var (
+	uptimeSecondsRegexp = regexp.MustCompile(`^\d+(\.\d+)?$`)
+	uptimeClockRegexp   = regexp.MustCompile(`^(\d+)h?:(\d+)m?:(\d+)s?(\.\d+)?$`)
+	uptimeUnitRegexp    = regexp.MustCompile(`(?i)(\d+)\s*(days?|d|hours?|hrs?|h|minutes?|mins?|m|seconds?|secs?|s)(\.\d+)?\b`)
+	uptimeSepRegexp     = regexp.MustCompile(`^[\s:,]*$`)
+)
+
+// uptimeUnitSeconds maps the first letter of a unit suffix to its length.
+var uptimeUnitSeconds = map[byte]float64{
+	'd': 24 * 60 * 60,
+	'h': 60 * 60,
+	'm': 60,
+	's': 1,
+}
+
+// ParseUptime parses the uptime shown by the modem in seconds, detecting
+// which of the formats used by different firmware it is in:
+//
+//	"days":    40 days 05h:32m:52s.00 or 1 day 00h:04m:12s
+//	"clock":   05:32:52 or 05h:32m:52s, hours may exceed 24
+//	"seconds": 3475972
+//
+// In the "days" format each component is read by its unit suffix, so one
+// that's missing doesn't shift the others.
+func ParseUptime(uptimeStr string) (uptime float64, format string, err error) {
+	uptimeStr = strings.TrimSpace(uptimeStr)
+	if uptimeSecondsRegexp.MatchString(uptimeStr) {
+		uptime, err = strconv.ParseFloat(uptimeStr, 64)
+		return uptime, "seconds", err
+	}
+	if match := uptimeClockRegexp.FindStringSubmatch(uptimeStr); match != nil {
+		for _, nStr := range match[1:4] {
+			n, _ := strconv.ParseFloat(nStr, 64)
+			uptime = uptime*60 + n
+		}
+		return uptime, "clock", nil
+	}
+
+	// Each component like "40 days" or "05h", fractions of a second ignored
+	matches := uptimeUnitRegexp.FindAllStringSubmatch(uptimeStr, -1)
+	seen := make(map[byte]bool, len(matches))
+	for _, match := range matches {
+		unit := strings.ToLower(match[2])[0]
+		if seen[unit] {
+			return 0, "", fmt.Errorf("unrecognised uptime %q", uptimeStr)
+		}
+		seen[unit] = true
+		n, _ := strconv.ParseFloat(match[1], 64)
+		uptime += n * uptimeUnitSeconds[unit]
+	}
+	if len(matches) == 0 || !uptimeSepRegexp.MatchString(uptimeUnitRegexp.ReplaceAllString(uptimeStr, "")) {
+		return 0, "", fmt.Errorf("unrecognised uptime %q", uptimeStr)
+	}
+	return uptime, "days", nil
+}
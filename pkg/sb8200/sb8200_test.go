@@ -0,0 +1,429 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// testModem is a fake modem serving the pages in testdata to the session its
+// login hands out, and the login form to anyone else.
+type testModem struct {
+	*httptest.Server
+	logins      int32 // Logins answered so far
+	emptyTokens int32 // Logins left to answer without a csrf token
+	refused     int32 // Page requests answered with the login form
+	drops       int32 // Page requests left to answer with the login form despite a valid session
+	inFlight    int32 // Page requests being served
+	maxInFlight int32 // Most page requests served at once
+
+	pageDelay time.Duration // Time taken to serve each page
+
+	mu        sync.Mutex
+	loggedOut []string // Session cookie sent with each logout, "" for none
+}
+
+func newTestModem(t *testing.T) *testModem {
+	modem := &testModem{}
+	modem.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logout.html" {
+			session := ""
+			if cookie, err := r.Cookie("sessionId"); err == nil {
+				session = cookie.Value
+			}
+			modem.mu.Lock()
+			modem.loggedOut = append(modem.loggedOut, session)
+			modem.mu.Unlock()
+			return
+		}
+		if strings.HasPrefix(r.URL.RawQuery, "login_") {
+			atomic.AddInt32(&modem.logins, 1)
+			http.SetCookie(w, &http.Cookie{Name: "sessionId", Value: "testsession"})
+			if atomic.AddInt32(&modem.emptyTokens, -1) >= 0 {
+				return
+			}
+			w.Write([]byte("testtoken"))
+			return
+		}
+		if cookie, err := r.Cookie("sessionId"); err != nil || cookie.Value != "testsession" || atomic.AddInt32(&modem.drops, -1) >= 0 {
+			atomic.AddInt32(&modem.refused, 1)
+			w.Write([]byte("<html><head><title>Login</title></head></html>"))
+			return
+		}
+
+		inFlight := atomic.AddInt32(&modem.inFlight, 1)
+		defer atomic.AddInt32(&modem.inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&modem.maxInFlight)
+			if inFlight <= max || atomic.CompareAndSwapInt32(&modem.maxInFlight, max, inFlight) {
+				break
+			}
+		}
+		time.Sleep(modem.pageDelay)
+		page, err := os.ReadFile(filepath.Join("testdata", filepath.Base(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(page)
+	}))
+	t.Cleanup(modem.Close)
+	return modem
+}
+
+func (modem *testModem) exporter() *Exporter {
+	return NewExporter(strings.TrimPrefix(modem.URL, "https://"), "admin", "password")
+}
+
+func TestScrapeConcurrentSharedSession(t *testing.T) {
+	modem := newTestModem(t)
+	// Slow enough for the page requests of the scrapes to overlap
+	modem.pageDelay = 20 * time.Millisecond
+	e := modem.exporter()
+	e.SessionTTL = time.Minute
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := e.Scrape()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if logins := atomic.LoadInt32(&modem.logins); logins != 1 {
+		t.Errorf("concurrent scrapes logged in %d times, want once", logins)
+	}
+	if maxInFlight := atomic.LoadInt32(&modem.maxInFlight); maxInFlight < 2 {
+		t.Errorf("at most %d page requests were in flight, want them concurrent", maxInFlight)
+	}
+}
+
+func TestParseLeadingFloatCommaDecimal(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  float64
+	}{
+		{"1,5 dB", 1.5},
+		{"3,4 dBmV", 3.4},
+		{"-0,75 dBmV", -0.75},
+		{"1.234,5", 1234.5},
+		{"1.234.567,25 Hz", 1234567.25},
+		{"1,234", 1234},
+		{"1,234,567", 1234567},
+		{"3.4 dBmV", 3.4},
+		{"44 dB", 44},
+	} {
+		got, err := parseLeadingFloat(tc.value)
+		if err != nil {
+			t.Errorf("parseLeadingFloat(%q): %v", tc.value, err)
+		} else if got != tc.want {
+			t.Errorf("parseLeadingFloat(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		value string
+		want  float64
+	}{
+		{"3,4 MHz", 3.4e6},
+		{"1.234,5 kHz", 1234.5e3},
+		{"495,000,000 Hz", 495e6},
+	} {
+		got, err := ParseFrequency(tc.value)
+		if err != nil {
+			t.Errorf("ParseFrequency(%q): %v", tc.value, err)
+		} else if got != tc.want {
+			t.Errorf("ParseFrequency(%q) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestRejectedSessionCookieNotReused(t *testing.T) {
+	modem := newTestModem(t)
+	e := modem.exporter()
+	e.SessionCookie = "stale"
+	for i := 0; i < 3; i++ {
+		if _, err := e.Scrape(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the first scrape offers the cookie, fetching its two pages with it
+	if refused := atomic.LoadInt32(&modem.refused); refused > 2 {
+		t.Errorf("%d page requests were refused, want the stale cookie offered only once", refused)
+	}
+	if logins := atomic.LoadInt32(&modem.logins); logins != 3 {
+		t.Errorf("logged in %d times, want once per scrape", logins)
+	}
+}
+
+func TestLoginLogsOutPreviousSession(t *testing.T) {
+	modem := newTestModem(t)
+	e := modem.exporter()
+	for i := 0; i < 2; i++ {
+		if _, err := e.Scrape(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modem.mu.Lock()
+	defer modem.mu.Unlock()
+	if len(modem.loggedOut) != 2 || modem.loggedOut[0] != "" || modem.loggedOut[1] != "testsession" {
+		t.Errorf("logouts sent session cookies %q, want none and then the first scrape's", modem.loggedOut)
+	}
+}
+
+func TestReloginDrawsFromRetryBudget(t *testing.T) {
+	modem := newTestModem(t)
+	e := modem.exporter()
+	e.Retries = 0
+	e.Concurrency = 1
+	atomic.StoreInt32(&modem.drops, 1)
+	if _, err := e.Scrape(); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("Scrape returned %v after the new session was dropped, want the retry budget exhausted", err)
+	}
+	if logins := atomic.LoadInt32(&modem.logins); logins != 1 {
+		t.Errorf("logged in %d times without a retry to spare, want 1", logins)
+	}
+
+	e = modem.exporter()
+	e.Retries = 1
+	e.Concurrency = 1
+	atomic.StoreInt32(&modem.drops, 1)
+	atomic.StoreInt32(&modem.logins, 0)
+	if _, err := e.Scrape(); err != nil {
+		t.Errorf("Scrape returned %v with a retry to log in again", err)
+	}
+	if logins := atomic.LoadInt32(&modem.logins); logins != 2 {
+		t.Errorf("logged in %d times, want 2", logins)
+	}
+}
+
+func TestLoginRetriesEmptyCSRFToken(t *testing.T) {
+	modem := newTestModem(t)
+	atomic.StoreInt32(&modem.emptyTokens, 1)
+	budget := NewRetryBudget(1, 0)
+	_, csrfToken, err := modem.exporter().Login(context.Background(), budget, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csrfToken != "testtoken" {
+		t.Errorf("csrf token %q, want testtoken", csrfToken)
+	}
+	if logins := atomic.LoadInt32(&modem.logins); logins != 2 {
+		t.Errorf("logged in %d times, want a retry after the empty token", logins)
+	}
+	if err := budget.Take(); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("the retry wasn't taken from the budget, Take returned %v", err)
+	}
+
+	// Without a retry to spare the empty token is a clear error
+	atomic.StoreInt32(&modem.emptyTokens, 1)
+	_, _, err = modem.exporter().Login(context.Background(), NewRetryBudget(0, 0), nil)
+	if !errors.Is(err, ErrRetryBudgetExhausted) || !strings.Contains(err.Error(), "empty csrf token") {
+		t.Errorf("Login returned %v, want an empty csrf token error", err)
+	}
+}
+
+func TestParseUptimeSeconds(t *testing.T) {
+	uptime, format, err := ParseUptime(" 3475972 ")
+	if err != nil || uptime != 3475972 || format != "seconds" {
+		t.Errorf("ParseUptime = %v, %q, %v, want 3475972 seconds", uptime, format, err)
+	}
+}
+
+func TestParseUptimeClock(t *testing.T) {
+	for _, uptimeStr := range []string{"05:32:52", "05h:32m:52s", "05h:32m:52s.00"} {
+		uptime, format, err := ParseUptime(uptimeStr)
+		if want := float64(5*3600 + 32*60 + 52); err != nil || uptime != want || format != "clock" {
+			t.Errorf("ParseUptime(%q) = %v, %q, %v, want %v clock", uptimeStr, uptime, format, err, want)
+		}
+	}
+	// Hours run past a day on firmware without a days component
+	if uptime, _, err := ParseUptime("100:00:01"); err != nil || uptime != 100*3600+1 {
+		t.Errorf("ParseUptime(100:00:01) = %v, %v", uptime, err)
+	}
+}
+
+func TestParseUptimeDays(t *testing.T) {
+	uptime, format, err := ParseUptime("40 days 05h:32m:52s.00")
+	if want := float64(40*86400 + 5*3600 + 32*60 + 52); err != nil || uptime != want || format != "days" {
+		t.Errorf("ParseUptime = %v, %q, %v, want %v days", uptime, format, err, want)
+	}
+}
+
+func TestParseUptimeUnitSuffixes(t *testing.T) {
+	for _, tc := range []struct {
+		uptimeStr string
+		want      float64
+		ok        bool
+	}{
+		{"40 days 05h:32m:52s.00", 40*86400 + 5*3600 + 32*60 + 52, true},
+		// Right after a reboot, without fractional seconds
+		{"0 days 00h:04m:12s", 4*60 + 12, true},
+		// Singular day
+		{"1 day 02h:00m:01s", 86400 + 2*3600 + 1, true},
+		// Missing components
+		{"1 day 02h", 86400 + 2*3600, true},
+		{"3 days 10s", 3*86400 + 10, true},
+		{"5h 12s", 5*3600 + 12, true},
+		// Reordered components
+		{"12s 5h", 5*3600 + 12, true},
+		{"04m:12s 0 days", 4*60 + 12, true},
+		// Spelled out units
+		{"3 hours, 2 minutes", 3*3600 + 2*60, true},
+		{"1d 2h 3m 4s", 86400 + 2*3600 + 3*60 + 4, true},
+		{"", 0, false},
+		{"unknown", 0, false},
+		{"1 day 2 days", 0, false},
+		{"1 fortnight 2h", 0, false},
+	} {
+		uptime, _, err := ParseUptime(tc.uptimeStr)
+		if tc.ok && (err != nil || uptime != tc.want) {
+			t.Errorf("ParseUptime(%q) = %v, %v, want %v", tc.uptimeStr, uptime, err, tc.want)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("ParseUptime(%q) = %v, want an error", tc.uptimeStr, uptime)
+		}
+	}
+}
+
+func TestScrapeUnitValueWhitespace(t *testing.T) {
+	for _, tc := range []struct {
+		cell string
+		want float64
+	}{
+		{"3.4 dBmV", 3.4},
+		{"3.4 dBmV", 3.4},
+		{"3.4&nbsp;dBmV", 3.4},
+		{"-1.2&nbsp;&nbsp;dBmV&nbsp;", -1.2},
+		{"  -1.2 dBmV   ", -1.2},
+		{"40.3 dB\t\n", 40.3},
+		{" 44.0 ", 44},
+		{"dBmV 44.0", 44},
+		{"12 ", 12},
+	} {
+		document, err := goquery.NewDocumentFromReader(strings.NewReader("<table><tr><td>" + tc.cell + "</td></tr></table>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ScrapeUnitValue(document.Find("tr"), 1)
+		if err != nil {
+			t.Errorf("ScrapeUnitValue(%q): %v", tc.cell, err)
+		} else if got != tc.want {
+			t.Errorf("ScrapeUnitValue(%q) = %v, want %v", tc.cell, got, tc.want)
+		}
+	}
+}
+
+// upstreamRow parses cells as a row of the upstream table.
+func upstreamRow(t *testing.T, cells ...string) *goquery.Selection {
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(
+		"<table><tr><td>" + strings.Join(cells, "</td><td>") + "</td></tr></table>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document.Find("tr")
+}
+
+func TestScrapeUpstreamTableRowWidth(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cells []string
+		width float64
+	}{
+		{"hz", []string{"1", "2", "Locked", "SC-QAM Upstream", "22800000 Hz", "6400000 Hz", "44.0 dBmV"}, 6400000},
+		{"mhz", []string{"1", "2", "Locked", "SC-QAM Upstream", "22.8 MHz", "6.4 MHz", "44.0 dBmV"}, 6400000},
+		// Firmware that adds SNR and T3/T4 timeout columns after the power
+		{"wide", []string{"1", "2", "Locked", "SC-QAM Upstream", "22800000 Hz", "3200000 Hz", "44.0 dBmV", "38.2 dB", "0", "1"}, 3200000},
+	} {
+		channel, err := ScrapeUpstreamTableRow(upstreamRow(t, tc.cells...))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if channel.Power != 44 {
+			t.Errorf("%s: power %v, want 44", tc.name, channel.Power)
+		}
+		width, err := ParseFrequency(channel.Width)
+		if err != nil || width != tc.width {
+			t.Errorf("%s: width %q parsed as %v, %v, want %v", tc.name, channel.Width, width, err, tc.width)
+		}
+	}
+
+	// A row cut short before the power column must not pass for a channel
+	short := upstreamRow(t, "1", "2", "Locked", "SC-QAM Upstream", "22800000 Hz", "6400000 Hz")
+	if channel, err := ScrapeUpstreamTableRow(short); err == nil {
+		t.Errorf("short row parsed as %+v, want an error", channel)
+	}
+}
+
+func TestCompletenessIgnoresOptionalFields(t *testing.T) {
+	modem := ArrisModem{
+		RawConnectivityState:     "OK",
+		HardwareVersion:          "6",
+		SoftwareVersion:          "AB01.02.053.05_051921_193.0A.NSH",
+		MACAddress:               "00:11:22:33:44:55",
+		SerialNumber:             "123456789",
+		DownstreamBondedChannels: []DownstreamChannel{{}},
+		UpstreamBondedChannels:   []UpstreamChannel{{}},
+		MaxDownstreamChannels:    math.NaN(),
+		MaxUpstreamChannels:      math.NaN(),
+	}
+	if got := modem.completeness(); got != 1 {
+		t.Errorf("completeness() = %v with only optional fields missing, want 1", got)
+	}
+	modem.SerialNumber = ""
+	if got, want := modem.completeness(), 6.0/7; got != want {
+		t.Errorf("completeness() = %v with the serial number missing, want %v", got, want)
+	}
+}
+
+func TestScrapeRecoversParserPanic(t *testing.T) {
+	modem := newTestModem(t)
+	parseStatusPage = func(document *goquery.Document) ConnectionStatus {
+		panic("malformed page")
+	}
+	defer func() { parseStatusPage = ParseStatusPage }()
+
+	_, err := modem.exporter().Scrape()
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("Scrape returned %v, want a PanicError", err)
+	}
+	if panicErr.Value != "malformed page" || len(panicErr.Stack) == 0 {
+		t.Errorf("got panic %v with a %d byte stack, want the parser's panic and its stack", panicErr.Value, len(panicErr.Stack))
+	}
+}
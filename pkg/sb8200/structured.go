@@ -0,0 +1,208 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// structuredStatus is the connection status as served by firmware that
+// offers an XML or JSON endpoint next to the HTML page. Values keep their
+// units (e.g. "3.4 dBmV") like the HTML tables do.
+type structuredStatus struct {
+	ConnectivityState string                        `xml:"connectivity_state" json:"connectivity_state"`
+	ConfigFile        string                        `xml:"configuration_file" json:"configuration_file"`
+	DOCSISVersion     string                        `xml:"docsis_version" json:"docsis_version"`
+	Downstream        []structuredDownstreamChannel `xml:"downstream>channel" json:"downstream"`
+	Upstream          []structuredUpstreamChannel   `xml:"upstream>channel" json:"upstream"`
+}
+
+type structuredDownstreamChannel struct {
+	ChannelID      string `xml:"channel_id" json:"channel_id"`
+	LockStatus     string `xml:"lock_status" json:"lock_status"`
+	Modulation     string `xml:"modulation" json:"modulation"`
+	Frequency      string `xml:"frequency" json:"frequency"`
+	Power          string `xml:"power" json:"power"`
+	SNR            string `xml:"snr" json:"snr"`
+	Corrected      string `xml:"corrected" json:"corrected"`
+	Uncorrectables string `xml:"uncorrectables" json:"uncorrectables"`
+	ProfileID      string `xml:"profile_id" json:"profile_id"` // Only on firmware reporting OFDM profiles
+}
+
+type structuredUpstreamChannel struct {
+	Channel     string `xml:"channel" json:"channel"`
+	ChannelID   string `xml:"channel_id" json:"channel_id"`
+	LockStatus  string `xml:"lock_status" json:"lock_status"`
+	ChannelType string `xml:"channel_type" json:"channel_type"`
+	Frequency   string `xml:"frequency" json:"frequency"`
+	Width       string `xml:"width" json:"width"`
+	Power       string `xml:"power" json:"power"`
+	SNR         string `xml:"snr" json:"snr"`                 // Only on newer firmware
+	T3Timeouts  string `xml:"t3_timeouts" json:"t3_timeouts"` // Only on firmware reporting them
+	T4Timeouts  string `xml:"t4_timeouts" json:"t4_timeouts"` // Only on firmware reporting them
+}
+
+// parseLeadingFloat parses the number at the start of a value like
+// "3.4 dBmV", ignoring the unit.
+func parseLeadingFloat(valStr string) (float64, error) {
+	num := leadingNumberRegexp.FindString(normalizeDecimal(valStr))
+	if num == "" {
+		return 0, fmt.Errorf("no number in %q", valStr)
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+// ParseStructuredStatus decodes an XML or JSON status document, picking the
+// decoder from the first non-whitespace byte.
+func ParseStructuredStatus(body []byte) (status ConnectionStatus, err error) {
+	var raw structuredStatus
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		err = xml.Unmarshal(trimmed, &raw)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		err = json.Unmarshal(trimmed, &raw)
+	default:
+		err = errors.New("status is neither XML nor JSON")
+	}
+	if err != nil {
+		return
+	}
+	if len(raw.Downstream) == 0 && len(raw.Upstream) == 0 {
+		err = errors.New("structured status has no channels")
+		return
+	}
+
+	status.FirmwareFamily = FirmwareFamilyStructured
+	status.ExpectedDownstreamChannels = math.NaN()
+	status.ExpectedUpstreamChannels = math.NaN()
+	status.RawConnectivityState = strings.TrimSpace(raw.ConnectivityState)
+	if status.RawConnectivityState == "OK" {
+		status.ConnectivityState = 1.
+	}
+	status.ConfigFileStatus = raw.ConfigFile
+	status.DOCSISVersion = docsisVersionRegexp.FindString(raw.DOCSISVersion)
+
+	for _, channel := range raw.Downstream {
+		var parsed DownstreamChannel
+		parsed, err = channel.parse()
+		if err != nil {
+			return
+		}
+		status.DownstreamChannels = append(status.DownstreamChannels, parsed)
+	}
+	for _, channel := range raw.Upstream {
+		var parsed UpstreamChannel
+		parsed, err = channel.parse()
+		if err != nil {
+			return
+		}
+		status.UpstreamChannels = append(status.UpstreamChannels, parsed)
+	}
+	return
+}
+
+func (c structuredDownstreamChannel) parse() (channel DownstreamChannel, err error) {
+	channel = DownstreamChannel{
+		ChannelID:  c.ChannelID,
+		Modulation: c.Modulation,
+		Frequency:  c.Frequency,
+		RawPower:   c.Power,
+		ProfileID:  math.NaN(),
+		Profile:    strings.TrimSpace(c.ProfileID),
+	}
+	if c.LockStatus == "Locked" {
+		channel.LockStatus = 1.
+	}
+	if channel.Power, err = parseLeadingFloat(c.Power); err != nil {
+		return
+	}
+	if channel.SNR, err = parseLeadingFloat(c.SNR); err != nil {
+		return
+	}
+	if channel.CorrectedErrors, err = parseLeadingFloat(c.Corrected); err != nil {
+		return
+	}
+	if channel.UncorrectableErrors, err = parseLeadingFloat(c.Uncorrectables); err != nil {
+		return
+	}
+	if c.ProfileID != "" {
+		channel.ProfileID, err = parseLeadingFloat(c.ProfileID)
+	}
+	return
+}
+
+func (c structuredUpstreamChannel) parse() (channel UpstreamChannel, err error) {
+	channel = UpstreamChannel{
+		Channel:       c.Channel,
+		ChannelID:     c.ChannelID,
+		USChannelType: c.ChannelType,
+		Frequency:     c.Frequency,
+		Width:         c.Width,
+		RawPower:      c.Power,
+		SNR:           math.NaN(),
+		T3Timeouts:    math.NaN(),
+		T4Timeouts:    math.NaN(),
+	}
+	if c.LockStatus == "Locked" {
+		channel.LockStatus = 1.
+	}
+	if channel.Power, err = parseLeadingFloat(c.Power); err != nil {
+		return
+	}
+	if c.SNR != "" {
+		if channel.SNR, err = parseLeadingFloat(c.SNR); err != nil {
+			return
+		}
+	}
+	if c.T3Timeouts != "" {
+		if channel.T3Timeouts, err = parseLeadingFloat(c.T3Timeouts); err != nil {
+			return
+		}
+	}
+	if c.T4Timeouts != "" {
+		channel.T4Timeouts, err = parseLeadingFloat(c.T4Timeouts)
+	}
+	return
+}
+
+// scrapeStructuredStatus fetches and decodes the structured status endpoint.
+func (e *Exporter) scrapeStructuredStatus(ctx context.Context, sessionID *http.Cookie, csrfToken string) (status ConnectionStatus, err error) {
+	url := fmt.Sprintf("%s://%s/%s?ct_%s", e.scheme(), e.Host, strings.TrimPrefix(e.StructuredStatusPath, "/"), csrfToken)
+	req, err := http.NewRequest(e.pageMethod(), url, nil)
+	if err != nil {
+		return
+	}
+	req.AddCookie(sessionID)
+
+	resp, body, err := e.do(ctx, req)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("structured status returned %s", resp.Status)
+		return
+	}
+	return ParseStructuredStatus(body)
+}
@@ -0,0 +1,36 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sb8200
+
+import "testing"
+
+func TestParseStructuredStatusPaddedConnectivityState(t *testing.T) {
+	downstream := `{"channel_id": "1", "lock_status": "Locked", "power": "3.4 dBmV", "snr": "40.1 dB", "corrected": "0", "uncorrectables": "0"}`
+	downstreamXML := `<channel_id>1</channel_id><lock_status>Locked</lock_status><power>3.4 dBmV</power><snr>40.1 dB</snr><corrected>0</corrected><uncorrectables>0</uncorrectables>`
+	for _, body := range []string{
+		`{"connectivity_state": " OK ", "downstream": [` + downstream + `]}`,
+		`<status><connectivity_state>
+			OK
+		</connectivity_state><downstream><channel>` + downstreamXML + `</channel></downstream></status>`,
+	} {
+		status, err := ParseStructuredStatus([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.RawConnectivityState != "OK" || status.ConnectivityState != 1 {
+			t.Errorf("connectivity state %q read as %v, want OK read as 1", status.RawConnectivityState, status.ConnectivityState)
+		}
+	}
+}
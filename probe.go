@@ -0,0 +1,62 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveProbe scrapes the modem named by the target parameter and serves only
+// its metrics, so one exporter can serve many modems that Prometheus relabels
+// onto /probe?target=<host> as it does for blackbox_exporter. Only modems in
+// targets can be probed, each logged into with its own credentials, so the
+// credentials are never sent to a host chosen by whoever calls /probe.
+func serveProbe(targets map[string]*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("target")
+		if err := validateHost(host); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid target parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		configured, ok := targets[host]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Target %s is not a configured modem", host), http.StatusForbidden)
+			return
+		}
+
+		exporter := buildExporter(host, "", "")
+		// Scraping through the configured exporter's modem shares its
+		// session, where logging in anew would log it out.
+		exporter.Exporter = configured.Exporter
+		// Nothing else carries over from one probe to the next, so there
+		// are no scrapes to warm up over.
+		exporter.WarmupScrapes = 0
+		exporter.ExpectedSerial, exporter.ExpectedMAC = configured.ExpectedSerial, configured.ExpectedMAC
+		// The histograms and the recorder are shared with the background
+		// exporters, probes mustn't add series or rows to them.
+		exporter.PowerHistogram = nil
+		exporter.UncorrectableHistogram = nil
+		exporter.PhaseHistogram = nil
+		exporter.Recorder = nil
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(exporter)
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
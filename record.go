@@ -0,0 +1,170 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ocelotsloth/sb8200-exporter/pkg/sb8200"
+	"github.com/prometheus/common/log"
+)
+
+// recordHeader names the columns of the -record.file CSV.
+var recordHeader = []string{
+	"time", "host", "direction", "channel_id", "frequency_hz", "locked",
+	"power_dbmv", "snr_db", "corrected", "uncorrectable", "error",
+}
+
+// Recorder appends the channels of every background scrape to a CSV file for
+// analysis without a Prometheus server, one row per channel per scrape and a
+// single row carrying the error for failed scrapes. It's shared by every
+// Exporter.
+type Recorder struct {
+	Path     string // File rows are appended to
+	MaxBytes int64  // Size at which Path is rotated to Path.1, 0 for no limit
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Record appends the rows of a scrape result, logging rather than returning
+// write errors so a full disk doesn't stop the scrapes.
+func (r *Recorder) Record(host string, result scrapeResult) {
+	rows := recordRows(host, result)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.write(rows); err != nil {
+		log.Errorf("Failed to record scrape of %s to %s: %v", host, r.Path, err)
+	}
+}
+
+func (r *Recorder) write(rows [][]string) error {
+	if r.MaxBytes > 0 && r.file != nil && r.size >= r.MaxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+
+	writer := csv.NewWriter(&countingWriter{file: r.file, size: &r.size})
+	if r.size == 0 {
+		writer.Write(recordHeader)
+	}
+	writer.WriteAll(rows)
+	return writer.Error()
+}
+
+// open opens Path for appending, remembering its size for rotation.
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// rotate moves Path to Path.1, replacing the previous one, so at most twice
+// MaxBytes is kept.
+func (r *Recorder) rotate() error {
+	r.file.Close()
+	r.file = nil
+	return os.Rename(r.Path, r.Path+".1")
+}
+
+// Close closes the file, the next Record opens it again.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// countingWriter adds the bytes written to a file to size.
+type countingWriter struct {
+	file *os.File
+	size *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	*w.size += int64(n)
+	return n, err
+}
+
+// recordRows turns a scrape result into CSV rows.
+func recordRows(host string, result scrapeResult) [][]string {
+	timestamp := result.time.UTC().Format(time.RFC3339)
+	if result.err != nil {
+		row := make([]string, len(recordHeader))
+		row[0], row[1], row[len(row)-1] = timestamp, host, result.err.Error()
+		return [][]string{row}
+	}
+
+	rows := make([][]string, 0, len(result.modem.DownstreamBondedChannels)+len(result.modem.UpstreamBondedChannels))
+	for _, channel := range result.modem.DownstreamBondedChannels {
+		rows = append(rows, []string{
+			timestamp, host, DOWNSTREAM, channel.ChannelID, recordFrequency(channel.Frequency), recordValue(channel.LockStatus),
+			recordValue(channel.Power), recordValue(channel.SNR),
+			recordValue(channel.CorrectedErrors), recordValue(channel.UncorrectableErrors), "",
+		})
+	}
+	for _, channel := range result.modem.UpstreamBondedChannels {
+		rows = append(rows, []string{
+			timestamp, host, UPSTREAM, channel.ChannelID, recordFrequency(channel.Frequency), recordValue(channel.LockStatus),
+			recordValue(channel.Power), recordValue(channel.SNR),
+			"", "", "",
+		})
+	}
+	return rows
+}
+
+// recordValue formats a reading for the CSV, leaving unreported (NaN)
+// readings empty.
+func recordValue(value float64) string {
+	if math.IsNaN(value) {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// recordFrequency converts a channel frequency to Hz for the CSV, leaving
+// frequencies that don't parse empty.
+func recordFrequency(frequency string) string {
+	hz, err := sb8200.ParseFrequency(frequency)
+	if err != nil {
+		return ""
+	}
+	return recordValue(hz)
+}
@@ -0,0 +1,159 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ocelotsloth/sb8200-exporter/pkg/sb8200"
+)
+
+// StreamEvent is a scrape result as written to /debug/stream.
+type StreamEvent struct {
+	Time  time.Time         `json:"time"`
+	Host  string            `json:"host"`
+	Error string            `json:"error,omitempty"`
+	Modem sb8200.ArrisModem `json:"modem"`
+}
+
+// subscribe returns a channel receiving every scrape result from now on, and
+// a function to stop receiving them. Results are dropped rather than holding
+// up scrapes when the subscriber falls behind.
+func (e *Exporter) subscribe() (<-chan StreamEvent, func()) {
+	events := make(chan StreamEvent, 8)
+	e.mu.Lock()
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan StreamEvent]struct{})
+	}
+	e.subscribers[events] = struct{}{}
+	e.mu.Unlock()
+	return events, func() {
+		e.mu.Lock()
+		delete(e.subscribers, events)
+		e.mu.Unlock()
+	}
+}
+
+// publish sends a scrape result to every subscriber.
+func (e *Exporter) publish(result scrapeResult) {
+	event := StreamEvent{Time: result.time, Host: e.Host, Modem: result.modem}
+	if result.err != nil {
+		event.Error = result.err.Error()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for events := range e.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// ServeStream writes every scrape result of every modem as a JSON line until
+// the client goes away.
+func (es Exporters) ServeStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := make(chan StreamEvent)
+	for _, e := range es {
+		subscription, unsubscribe := e.subscribe()
+		defer unsubscribe()
+		go func() {
+			for {
+				select {
+				case event := <-subscription:
+					select {
+					case events <- event:
+					case <-r.Context().Done():
+						return
+					}
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := encoder.Encode(jsonSafe(reflect.ValueOf(event))); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// jsonSafe converts a value into maps, slices and scalars, replacing the NaN
+// the scraper uses for unreported readings with null since JSON has no NaN.
+func jsonSafe(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return jsonSafe(value.Elem())
+	case reflect.Float32, reflect.Float64:
+		if f := value.Float(); !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = jsonSafe(value.Index(i))
+		}
+		return items
+	case reflect.Struct:
+		if _, ok := value.Interface().(json.Marshaler); ok {
+			return value.Interface()
+		}
+		fields := make(map[string]interface{})
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ","); tag[0] != "" {
+				name = tag[0]
+			}
+			if strings.HasSuffix(field.Tag.Get("json"), ",omitempty") && value.Field(i).IsZero() {
+				continue
+			}
+			fields[name] = jsonSafe(value.Field(i))
+		}
+		return fields
+	default:
+		return value.Interface()
+	}
+}
@@ -0,0 +1,91 @@
+// arris_cm_exporter, a Prometheus exporter for Arris Cable Modems
+// Copyright 2021 Mark Stenglein
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cidrList is a repeatable flag of networks allowed to reach the exporter.
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	cidrs := make([]string, len(*l))
+	for i, cidr := range *l {
+		cidrs[i] = cidr.String()
+	}
+	return strings.Join(cidrs, ",")
+}
+
+func (l *cidrList) Set(value string) error {
+	_, cidr, err := net.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, cidr)
+	return nil
+}
+
+func (l cidrList) contains(ip net.IP) bool {
+	for _, cidr := range l {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client making a request. The
+// X-Forwarded-For header is only trusted when the request comes from a
+// reverse proxy on the same host, otherwise anybody could spoof it.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		if forwardedIP := net.ParseIP(strings.TrimSpace(strings.Split(forwarded, ",")[0])); forwardedIP != nil {
+			return forwardedIP
+		}
+	}
+	return ip
+}
+
+// loopbackCIDRs are the networks of the exporter's own host.
+var loopbackCIDRs = cidrList{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+}
+
+// allowCIDRs only lets requests from the allowed networks through to next,
+// answering 403 to everybody else. An empty list allows everybody.
+func allowCIDRs(allowed cidrList, next http.Handler) http.Handler {
+	if len(allowed) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); ip == nil || !allowed.contains(ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}